@@ -7,6 +7,10 @@ package cli
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
 
 	"go.astrophena.name/gen/scaffold"
 	"go.astrophena.name/gen/site"
@@ -73,6 +77,59 @@ func Run(args []string) error {
 				Usage:  "Build and serve the site locally",
 				Action: serve,
 			},
+			{
+				Name:  "check",
+				Usage: "Check the built site for problems",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "links",
+						Usage: "Build the site and check that links point to existing pages",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "external",
+								Usage: "check links to other sites too",
+							},
+							&cli.IntFlag{
+								Name:  "concurrency",
+								Usage: "send at most `N` requests at once",
+								Value: 4,
+							},
+							&cli.Float64Flag{
+								Name:  "rate",
+								Usage: "send at most `N` requests per second (0 is unlimited)",
+								Value: 10,
+							},
+							&cli.StringFlag{
+								Name:  "cache",
+								Usage: "remember successfully checked external links in `FILE`",
+							},
+							&cli.DurationFlag{
+								Name:  "timeout",
+								Usage: "wait for a response at most `DURATION`",
+								Value: 10 * time.Second,
+							},
+						},
+						Action: checkLinks,
+					},
+				},
+			},
+			{
+				Name:  "gen-css",
+				Usage: "Write a stylesheet for syntax highlighting",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "style",
+						Usage: "use highlighting `STYLE`",
+						Value: site.DefaultHighlightStyle,
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "write to `FILE` instead of standard output",
+					},
+				},
+				Action: genCSS,
+			},
 			{
 				Name:  "new",
 				Usage: "Generate a new site",
@@ -119,3 +176,49 @@ func serve(c *cli.Context) error {
 	}
 	return s.Serve(c.String("addr"))
 }
+
+func checkLinks(c *cli.Context) error {
+	s, err := newSite(c)
+	if err != nil {
+		return err
+	}
+	if err := s.Build(); err != nil {
+		return err
+	}
+
+	broken, err := s.CheckLinks(site.CheckLinksOptions{
+		External:    c.Bool("external"),
+		Concurrency: c.Int("concurrency"),
+		Rate:        c.Float64("rate"),
+		CacheFile:   c.String("cache"),
+		Client:      &http.Client{Timeout: c.Duration("timeout")},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, l := range broken {
+		fmt.Println(l)
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("found %d broken links", len(broken))
+	}
+	return nil
+}
+
+func genCSS(c *cli.Context) error {
+	out := c.String("output")
+	if out == "" {
+		return site.WriteHighlightCSS(os.Stdout, c.String("style"))
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := site.WriteHighlightCSS(f, c.String("style")); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
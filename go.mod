@@ -3,10 +3,14 @@ module go.astrophena.name/gen
 go 1.16
 
 require (
+	github.com/alecthomas/chroma v0.10.0
+	github.com/evanw/esbuild v0.14.54
 	github.com/russross/blackfriday/v2 v2.1.0
 	github.com/tdewolff/minify v2.3.6+incompatible
-	github.com/tdewolff/parse v2.3.4+incompatible // indirect
+	github.com/tdewolff/parse v2.3.4+incompatible
 	github.com/tdewolff/test v1.0.6 // indirect
 	github.com/urfave/cli/v2 v2.3.0
+	github.com/yuin/goldmark v1.4.11
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
 	gopkg.in/yaml.v2 v2.4.0
 )
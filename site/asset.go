@@ -0,0 +1,169 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultManifest is a name of the fingerprinted assets manifest that
+// is used when none is configured.
+const DefaultManifest = "assets.json"
+
+// FingerprintConfig configures fingerprinting of static files.
+type FingerprintConfig struct {
+	// Files are glob patterns of static files that get a copy with a
+	// hash of their contents in the name, e.g. "sitewide.css" gets
+	// "sitewide.3f2a9c1b.css". The original is kept for references
+	// that don't go through the asset function, such as url() in
+	// stylesheets. A pattern without a slash matches file names,
+	// otherwise paths relative to the static directory.
+	Files []string `yaml:"files"`
+
+	// Manifest is a name of the JSON file in the output directory that
+	// maps paths of fingerprinted files to their new paths. Default is
+	// "assets.json".
+	Manifest string `yaml:"manifest"`
+}
+
+// validate reports whether the patterns are malformed.
+func (c *FingerprintConfig) validate() error {
+	for _, pattern := range c.Files {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("fingerprint: %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// match reports whether the static file rel is fingerprinted.
+func (c *FingerprintConfig) match(rel string) bool { return matchPath(c.Files, rel) }
+
+// Asset is a static file.
+type Asset struct {
+	Path      string // path in the output directory, fingerprinted or not
+	URL       string // URL relative to the host
+	Integrity string // Subresource Integrity value, e.g. "sha384-..."
+}
+
+// fingerprintStatic copies static files that match the fingerprint
+// patterns in the output directory to fingerprinted names.
+func (s *Site) fingerprintStatic() error {
+	s.assets = make(map[string]*Asset)
+	s.fingerprinted = make(map[string]bool)
+	s.manifest = make(map[string]string)
+
+	files, err := layeredFiles(s.lookupDirs(StaticDir))
+	if err != nil {
+		return err
+	}
+	s.static = make(map[string]bool)
+	for rel := range files {
+		s.static[rel] = true
+	}
+	if len(s.cfg.Fingerprint.Files) == 0 {
+		return nil
+	}
+
+	for _, rel := range sortedKeys(files) {
+		if !s.cfg.Fingerprint.match(rel) {
+			continue
+		}
+		if err := s.fingerprint(rel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeManifest writes the manifest of fingerprinted files, if
+// fingerprinting is enabled.
+func (s *Site) writeManifest() error {
+	if len(s.cfg.Fingerprint.Files) == 0 {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := s.cfg.Fingerprint.Manifest
+	if name == "" {
+		name = DefaultManifest
+	}
+	return os.WriteFile(filepath.Join(s.dst, filepath.FromSlash(name)), append(b, '\n'), 0644)
+}
+
+// fingerprint copies the file rel in the output directory to a name
+// that includes a hash of its contents.
+func (s *Site) fingerprint(rel string) error {
+	file := filepath.Join(s.dst, filepath.FromSlash(rel))
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	hashed := fingerprintName(rel, b)
+	if err := os.WriteFile(filepath.Join(s.dst, filepath.FromSlash(hashed)), b, 0644); err != nil {
+		return err
+	}
+
+	s.assets[rel] = &Asset{Path: hashed, Integrity: integrity(b)}
+	s.fingerprinted[hashed] = true
+	s.manifest[rel] = hashed
+	return nil
+}
+
+// fingerprintName returns the file name with a hash of contents b, e.g.
+// "sitewide.3f2a9c1b.css".
+func fingerprintName(name string, b []byte) string {
+	sum := sha256.Sum256(b)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// asset returns the static file name, with the fingerprinted URL if the
+// file is fingerprinted.
+func (s *Site) asset(name string) (*Asset, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	a, ok := s.assets[name]
+	if !ok {
+		if !s.static[name] {
+			return nil, fmt.Errorf("asset %s: no such static file", name)
+		}
+		b, err := os.ReadFile(filepath.Join(s.dst, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, fmt.Errorf("asset %s: %w", name, err)
+		}
+		a = &Asset{Path: name, Integrity: integrity(b)}
+		s.assets[name] = a
+	}
+
+	if a.URL == "" {
+		u, err := s.relURL(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		a.URL = u
+	}
+	return a, nil
+}
+
+// integrity returns the Subresource Integrity value of b.
+func integrity(b []byte) string {
+	sum := sha512.Sum384(b)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
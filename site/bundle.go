@@ -0,0 +1,214 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+)
+
+// Resource is a file of a page bundle.
+type Resource struct {
+	Name      string // path relative to the bundle directory, e.g. "images/cat.jpg"
+	MediaType string // e.g. "image/jpeg"
+	URL       string // URL relative to the host
+	Permalink string // absolute URL
+
+	src string // source file path
+	dst string // output file path, relative to the output directory
+	s   *Site
+
+	width, height int // of images, once decoded
+}
+
+// Content returns contents of the resource file.
+func (r *Resource) Content() (string, error) {
+	b, err := os.ReadFile(r.src)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Resources are files of a page bundle, ordered by name.
+type Resources []*Resource
+
+// Get returns the resource by name, or nil.
+func (rs Resources) Get(name string) *Resource {
+	for _, r := range rs {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Match returns resources whose names match the glob pattern, e.g.
+// "images/*.jpg". The pattern syntax is the one of path.Match.
+func (rs Resources) Match(pattern string) (Resources, error) {
+	var matched Resources
+	for _, r := range rs {
+		ok, err := path.Match(pattern, r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("Match %s: %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, r)
+		}
+	}
+	return matched, nil
+}
+
+// ByType returns resources of the media type typ, either full
+// ("image/png") or main ("image").
+func (rs Resources) ByType(typ string) Resources {
+	var matched Resources
+	for _, r := range rs {
+		if r.MediaType == typ || strings.HasPrefix(r.MediaType, typ+"/") {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// Resources returns files of the page bundle.
+func (p *Page) Resources() Resources { return p.resources }
+
+// bundleFile is a file of a page bundle.
+type bundleFile struct {
+	name string // path relative to the bundle directory
+	src  string // source file path
+}
+
+// collectBundles finds files of page bundles. A directory with an index
+// page is a bundle, and files other than pages in it and its
+// subdirectories belong to the bundle, unless a subdirectory is a
+// bundle itself. Translations of the index page share files.
+//
+// The pages directory (or a language directory) isn't a bundle, even
+// with an index page, so stray files don't end up as resources of the
+// home page.
+func (s *Site) collectBundles(pages []*Page) error {
+	bundles := make(map[string][]*Page)
+	for _, p := range pages {
+		if isIndex(p.key) && path.Dir(p.key) != "." {
+			dir := path.Dir(p.path)
+			bundles[dir] = append(bundles[dir], p)
+		}
+	}
+	if len(bundles) == 0 {
+		return nil
+	}
+
+	files, err := fileutil.Files(s.pagesDir())
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if isPageFormat(file) || strings.HasPrefix(filepath.Base(file), ".") {
+			continue
+		}
+		rel, err := filepath.Rel(s.pagesDir(), file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for dir := path.Dir(rel); ; dir = path.Dir(dir) {
+			if bp, ok := bundles[dir]; ok {
+				name := strings.TrimPrefix(rel, dir+"/")
+				for _, p := range bp {
+					p.bundle = append(p.bundle, bundleFile{name: name, src: file})
+				}
+				break
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// linkResources makes resources of pages from their bundle files. It's
+// done when page URIs are final, because resources are written next to
+// pages. Resources of an index page with an ".html" URI are written
+// into the directory of its output file, so they may collide with
+// resources of another page.
+func (s *Site) linkResources() error {
+	written := make(map[string]string) // output file -> source file
+	for _, p := range s.pages {
+		p.resources = nil
+		for _, f := range p.bundle {
+			r := &Resource{
+				Name:      f.name,
+				MediaType: mediaType(f.name),
+				src:       f.src,
+				s:         s,
+				dst:       path.Join(path.Dir(strings.TrimPrefix(p.URI, "/")), f.name),
+			}
+			// Translations share source files.
+			if src, ok := written[r.dst]; ok && src != r.src {
+				return fmt.Errorf("%s: resource %s is written to %s, as %s", s.sourceFile(p), f.name, r.dst, src)
+			}
+			written[r.dst] = r.src
+
+			var err error
+			if r.URL, err = s.relURL(r.dst); err != nil {
+				return err
+			}
+			if r.Permalink, err = s.absURL(r.dst); err != nil {
+				return err
+			}
+			p.resources = append(p.resources, r)
+		}
+	}
+	return nil
+}
+
+// copyResources copies resources of the page to the output directory.
+func (p *Page) copyResources() error {
+	for _, r := range p.resources {
+		dst := filepath.Join(p.s.dst, filepath.FromSlash(r.dst))
+		if err := fileutil.Mkdir(filepath.Dir(dst)); err != nil {
+			return err
+		}
+		if err := fileutil.CopyFile(r.src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isPageFormat(file string) bool {
+	for _, ext := range SupportedFormats {
+		if filepath.Ext(file) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns a media type of the file by its extension.
+func mediaType(file string) string {
+	typ := mime.TypeByExtension(path.Ext(file))
+	if typ == "" {
+		return "application/octet-stream"
+	}
+	if i := strings.IndexByte(typ, ';'); i >= 0 {
+		typ = typ[:i]
+	}
+	return typ
+}
@@ -0,0 +1,473 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.astrophena.name/gen/fileutil"
+
+	parsehtml "github.com/tdewolff/parse/html"
+)
+
+// CheckLinksOptions configures checking of links.
+type CheckLinksOptions struct {
+	// External enables checking of links to other sites with HEAD
+	// requests.
+	External bool
+
+	// Concurrency is a maximum number of concurrent requests. Default
+	// is 4.
+	Concurrency int
+
+	// Rate is a maximum number of requests per second. Zero means no
+	// limit.
+	Rate float64
+
+	// CacheFile is a file that keeps URLs that were successfully
+	// checked, so they aren't checked again for CacheTTL.
+	CacheFile string
+
+	// CacheTTL is how long results in CacheFile are valid. Default is
+	// 24 hours.
+	CacheTTL time.Duration
+
+	// Client is used for requests. Default is a client with a 10
+	// seconds timeout.
+	Client *http.Client
+}
+
+// BrokenLink is a link that points to nothing.
+type BrokenLink struct {
+	File       string // output file, relative to the destination directory
+	Line       int    // line of the output file, zero if it's minified
+	Source     string // source file of the page, relative to the pages directory, if known
+	SourceLine int    // line of the source file, zero if the link isn't there
+	URL        string
+	Reason     string
+}
+
+func (l *BrokenLink) String() string {
+	where := l.File
+	if l.Line > 0 {
+		where = fmt.Sprintf("%s:%d", where, l.Line)
+	}
+	if l.Source != "" {
+		src := l.Source
+		if l.SourceLine > 0 {
+			src = fmt.Sprintf("%s:%d", src, l.SourceLine)
+		}
+		where = fmt.Sprintf("%s (%s)", src, where)
+	}
+	return fmt.Sprintf("%s: %s: %s", where, l.URL, l.Reason)
+}
+
+// CheckLinks checks that href and src attributes of generated HTML
+// files point to existing files and fragments. Links to other sites are
+// checked only if opts.External is set.
+//
+// Source files of pages are known only after the site was built.
+func (s *Site) CheckLinks(opts CheckLinksOptions) ([]*BrokenLink, error) {
+	files, err := fileutil.Files(s.dst, ".html")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*Page) // by output file
+	for _, p := range s.pages {
+		pages[strings.TrimPrefix(p.URI, "/")] = p
+	}
+
+	c := &linkChecker{
+		dst:    s.dst,
+		parsed: make(map[string]*htmlFile),
+	}
+	if s.cfg.BaseURL != "" {
+		base, err := url.Parse(s.cfg.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base_url: %w", err)
+		}
+		c.base = base
+	}
+
+	var (
+		broken   []*BrokenLink
+		external = make(map[string][]*BrokenLink) // URL -> links to it
+		srcLines = make(map[string][]string)      // page source file -> its lines
+	)
+	for _, file := range files {
+		rel, err := filepath.Rel(s.dst, file)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+
+		f, err := c.parse(rel)
+		if err != nil {
+			return nil, err
+		}
+
+		// Lines of minified files are meaningless.
+		minified := s.minify && !s.cfg.Minify.excluded(rel)
+		seen := make(map[string]int) // URL -> number of links to it before
+		p := pages[rel]
+		for _, l := range f.links {
+			link := &BrokenLink{File: rel, URL: l.url}
+			if p != nil {
+				link.Source = p.path
+			}
+			if !minified {
+				link.Line = l.line
+			}
+			if link.Source != "" {
+				lines, ok := srcLines[link.Source]
+				if !ok {
+					b, err := os.ReadFile(filepath.Join(s.pagesDir(), filepath.FromSlash(link.Source)))
+					if err != nil {
+						return nil, err
+					}
+					lines = strings.Split(string(b), "\n")
+					srcLines[link.Source] = lines
+				}
+				link.SourceLine = sourceLine(lines, append([]string{l.url}, p.links[l.url]...), seen[l.url])
+				seen[l.url]++
+			}
+
+			u, err := url.Parse(l.url)
+			if err != nil {
+				link.Reason = "invalid URL"
+				broken = append(broken, link)
+				continue
+			}
+
+			if c.isExternal(u) {
+				if opts.External {
+					if u.Scheme == "" {
+						u.Scheme = "https"
+					}
+					u.Fragment = ""
+					external[u.String()] = append(external[u.String()], link)
+				}
+				continue
+			}
+			if u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https" {
+				continue // mailto:, tel: and so on
+			}
+
+			reason, err := c.checkInternal(rel, u)
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				link.Reason = reason
+				broken = append(broken, link)
+			}
+		}
+	}
+
+	if len(external) > 0 {
+		b, err := checkExternal(external, opts)
+		if err != nil {
+			return nil, err
+		}
+		broken = append(broken, b...)
+	}
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].File != broken[j].File {
+			return broken[i].File < broken[j].File
+		}
+		if broken[i].SourceLine != broken[j].SourceLine {
+			return broken[i].SourceLine < broken[j].SourceLine
+		}
+		return broken[i].Line < broken[j].Line
+	})
+	return broken, nil
+}
+
+// sourceLine returns the line number of the n-th (zero-based) occurrence
+// of any of urls in the source file lines, or zero if there's no such
+// one. Besides the link URL, urls has links to page source files that
+// were resolved to it.
+func sourceLine(lines []string, urls []string, n int) int {
+	for i, line := range lines {
+		var c int
+		for _, url := range urls {
+			c += strings.Count(line, url)
+		}
+		if n < c {
+			return i + 1
+		}
+		n -= c
+	}
+	return 0
+}
+
+// linkChecker checks links inside the destination directory.
+type linkChecker struct {
+	dst    string
+	base   *url.URL             // base URL of the site, if set
+	parsed map[string]*htmlFile // by file, relative to dst
+}
+
+// isExternal reports whether u points to another site.
+func (c *linkChecker) isExternal(u *url.URL) bool {
+	if u.Host == "" {
+		return false
+	}
+	return c.base == nil || !strings.EqualFold(u.Host, c.base.Host)
+}
+
+// checkInternal checks the link u from the file and returns why it's
+// broken, or an empty string if it isn't.
+func (c *linkChecker) checkInternal(file string, u *url.URL) (string, error) {
+	target := file
+	if u.Path != "" {
+		p := u.Path
+		if strings.HasPrefix(p, "/") {
+			if c.base != nil && c.base.Path != "" && c.base.Path != "/" {
+				basePath := strings.TrimSuffix(c.base.Path, "/")
+				if p != basePath && !strings.HasPrefix(p, basePath+"/") {
+					return "not found", nil
+				}
+				p = strings.TrimPrefix(p, basePath)
+			}
+		} else {
+			p = path.Join(path.Dir("/"+file), p)
+		}
+		target = strings.TrimPrefix(path.Clean("/"+p), "/")
+
+		fi, err := os.Stat(filepath.Join(c.dst, filepath.FromSlash(target)))
+		if err == nil && fi.IsDir() {
+			target = path.Join(target, "index.html")
+			fi, err = os.Stat(filepath.Join(c.dst, filepath.FromSlash(target)))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			return "not found", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if u.Fragment == "" || path.Ext(target) != ".html" {
+		return "", nil
+	}
+
+	f, err := c.parse(target)
+	if err != nil {
+		return "", err
+	}
+	if !f.ids[u.Fragment] {
+		return fmt.Sprintf("fragment #%s not found", u.Fragment), nil
+	}
+	return "", nil
+}
+
+// parse returns links and IDs of the HTML file, relative to the
+// destination directory.
+func (c *linkChecker) parse(file string) (*htmlFile, error) {
+	if f, ok := c.parsed[file]; ok {
+		return f, nil
+	}
+	f, err := parseHTMLFile(filepath.Join(c.dst, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, err
+	}
+	c.parsed[file] = f
+	return f, nil
+}
+
+// htmlFile contains links and IDs of elements of an HTML file.
+type htmlFile struct {
+	links []htmlLink
+	ids   map[string]bool
+}
+
+type htmlLink struct {
+	url  string
+	line int
+}
+
+func parseHTMLFile(name string) (*htmlFile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &htmlFile{ids: make(map[string]bool)}
+	l := parsehtml.NewLexer(bytes.NewReader(b))
+	var (
+		line = 1
+		tag  string
+	)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case parsehtml.ErrorToken:
+			if err := l.Err(); err != io.EOF {
+				return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+			}
+			return f, nil
+		case parsehtml.StartTagToken:
+			tag = string(l.Text())
+		case parsehtml.AttributeToken:
+			// The attribute starts after leading whitespace, that may
+			// contain line breaks.
+			ws := len(data) - len(bytes.TrimLeft(data, " \t\r\n\f"))
+			attrLine := line + bytes.Count(data[:ws], []byte("\n"))
+
+			val := html.UnescapeString(strings.Trim(string(l.AttrVal()), `"'`))
+			switch string(l.Text()) {
+			case "id":
+				f.ids[val] = true
+			case "name":
+				if tag == "a" {
+					f.ids[val] = true
+				}
+			case "href", "src":
+				if val = strings.TrimSpace(val); val != "" {
+					f.links = append(f.links, htmlLink{url: val, line: attrLine})
+				}
+			}
+		}
+		line += bytes.Count(data, []byte("\n"))
+	}
+}
+
+// checkExternal checks links to other sites.
+func checkExternal(links map[string][]*BrokenLink, opts CheckLinksOptions) ([]*BrokenLink, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 4
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = 24 * time.Hour
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	cache := make(map[string]time.Time) // URL -> when it was checked
+	if opts.CacheFile != "" {
+		b, err := os.ReadFile(opts.CacheFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &cache); err != nil {
+				return nil, fmt.Errorf("%s: %w", opts.CacheFile, err)
+			}
+		}
+	}
+
+	var urls []string
+	for u := range links {
+		if t, ok := cache[u]; ok && time.Since(t) < opts.CacheTTL {
+			continue
+		}
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		broken  []*BrokenLink
+		queue   = make(chan string)
+		checked = time.Now()
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				reason := checkURL(client, u)
+
+				mu.Lock()
+				if reason == "" {
+					cache[u] = checked
+				} else {
+					for _, l := range links[u] {
+						l.Reason = reason
+						broken = append(broken, l)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, u := range urls {
+		if tick != nil {
+			<-tick
+		}
+		queue <- u
+	}
+	close(queue)
+	wg.Wait()
+
+	if opts.CacheFile != "" {
+		b, err := json.MarshalIndent(cache, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(opts.CacheFile, b, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return broken, nil
+}
+
+// checkURL requests u and returns why it's broken, or an empty string
+// if it isn't.
+func checkURL(client *http.Client, u string) string {
+	status, err := requestStatus(client, http.MethodHead, u)
+	// Some servers don't support HEAD requests.
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = requestStatus(client, http.MethodGet, u)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	if status >= 400 {
+		return fmt.Sprintf("%d %s", status, http.StatusText(status))
+	}
+	return ""
+}
+
+func requestStatus(client *http.Client, method, u string) (int, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,200 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+)
+
+// DefaultCompressMinSize is a minimum size of compressed files in bytes
+// that is used when none is configured.
+const DefaultCompressMinSize = 1024
+
+// defaultCompressTypes are media types of compressed files that are used
+// when none are configured.
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"application/atom+xml",
+	"application/rss+xml",
+	"image/svg+xml",
+}
+
+// CompressConfig configures precompressed output.
+type CompressConfig struct {
+	// Gzip writes a gzipped copy (FILE.gz) of every output file of a
+	// compressible type, as nginx gzip_static expects.
+	Gzip bool `yaml:"gzip"`
+
+	// MinSize is a minimum size of files to compress in bytes. Default
+	// is 1024.
+	MinSize int `yaml:"min_size"`
+
+	// Types are media types of files to compress. A type can be a glob
+	// pattern, e.g. "text/*". Default is text, JavaScript, JSON, XML,
+	// feeds and SVG.
+	Types []string `yaml:"types"`
+}
+
+// validate reports whether the type patterns are malformed.
+func (c *CompressConfig) validate() error {
+	for _, typ := range c.Types {
+		if _, err := path.Match(typ, ""); err != nil {
+			return fmt.Errorf("compress: %s: %w", typ, err)
+		}
+	}
+	return nil
+}
+
+// compressible reports whether the file of media type typ is
+// compressed.
+func (c *CompressConfig) compressible(typ string) bool {
+	types := c.Types
+	if len(types) == 0 {
+		types = defaultCompressTypes
+	}
+	for _, pattern := range types {
+		if ok, _ := path.Match(pattern, typ); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compressOutput writes gzipped copies of compressible output files.
+func (s *Site) compressOutput() error {
+	if !s.cfg.Compress.Gzip {
+		return nil
+	}
+
+	minSize := s.cfg.Compress.MinSize
+	if minSize == 0 {
+		minSize = DefaultCompressMinSize
+	}
+
+	files, err := fileutil.Files(s.dst)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if filepath.Ext(file) == ".gz" || !s.cfg.Compress.compressible(mediaType(file)) {
+			continue
+		}
+		if err := gzipFile(file, minSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gzipFile writes a gzipped copy of the file next to it, unless the file
+// is smaller than minSize or compression doesn't make it smaller.
+func gzipFile(file string, minSize int) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if fi.Size() < int64(minSize) {
+		return nil
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	zw.Name = filepath.Base(file)
+	zw.ModTime = fi.ModTime()
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if buf.Len() >= len(b) {
+		return nil
+	}
+
+	if err := os.WriteFile(file+".gz", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	// Servers such as nginx expect the same modification time.
+	return os.Chtimes(file+".gz", fi.ModTime(), fi.ModTime())
+}
+
+// serveGzip serves the gzipped copy of the output file name, if it
+// exists and the client accepts gzip, and reports whether it did.
+func (s *Site) serveGzip(w http.ResponseWriter, r *http.Request, name string) bool {
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	file := filepath.Join(s.dst, filepath.FromSlash(path.Clean("/"+name)))
+
+	fi, err := os.Stat(file + ".gz")
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		return false
+	}
+
+	f, err := os.Open(file + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Encoding", "gzip")
+	typ := mime.TypeByExtension(filepath.Ext(file))
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", typ)
+	http.ServeContent(w, r, filepath.Base(file), fi.ModTime(), f)
+	return true
+}
+
+// acceptsGzip reports whether the Accept-Encoding header value allows
+// gzip.
+func acceptsGzip(header string) bool {
+	for _, enc := range strings.Split(header, ",") {
+		name, q := enc, ""
+		if i := strings.IndexByte(enc, ';'); i >= 0 {
+			name, q = enc[:i], strings.TrimSpace(enc[i+1:])
+		}
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		if strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
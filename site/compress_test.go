@@ -0,0 +1,90 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeGzip(t *testing.T) {
+	s := &Site{dst: t.TempDir()}
+
+	html := strings.Repeat("<p>Hello, world!</p>\n", 100)
+	for _, name := range []string{"index.html", "docs/index.html", "small.txt"} {
+		file := filepath.Join(s.dst, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(html), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "small.txt" {
+			if err := gzipFile(file, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		path, accept string
+		gzipped      bool
+		vary         bool
+	}{
+		{path: "/", accept: "gzip, deflate, br", gzipped: true, vary: true},
+		{path: "/index.html", accept: "br;q=1.0, gzip;q=0.8", gzipped: true, vary: true},
+		{path: "/docs/", accept: "*", gzipped: true, vary: true},
+		{path: "/docs/", accept: "gzip;q=0", vary: true},
+		{path: "/docs/", accept: "", vary: true},
+		{path: "/small.txt", accept: "gzip"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept-Encoding", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		s.fs().ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s (%s): got status %d", tc.path, tc.accept, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tc.vary {
+			t.Errorf("%s (%s): got Vary %q", tc.path, tc.accept, w.Header().Get("Vary"))
+		}
+
+		body := io.Reader(w.Body)
+		if tc.gzipped {
+			if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+				t.Errorf("%s (%s): got Content-Encoding %q, want gzip", tc.path, tc.accept, enc)
+				continue
+			}
+			if typ := w.Header().Get("Content-Type"); typ != "text/html; charset=utf-8" {
+				t.Errorf("%s (%s): got Content-Type %q", tc.path, tc.accept, typ)
+			}
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s (%s): %v", tc.path, tc.accept, err)
+			}
+			body = zr
+		} else if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s (%s): got Content-Encoding %q", tc.path, tc.accept, enc)
+		}
+
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != html {
+			t.Errorf("%s (%s): got wrong body", tc.path, tc.accept)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is a name of the optional site configuration file.
+const ConfigFile = "gen.yaml"
+
+// Config represents a site configuration.
+type Config struct {
+	// BaseURL is an URL the site is served from, e.g.
+	// "https://example.com/". It's used by absURL and relURL template
+	// functions.
+	BaseURL string `yaml:"base_url"`
+
+	// Theme is a name of the theme from the themes directory. Files in
+	// the site templates and static directories override theme files
+	// at the same paths.
+	Theme string `yaml:"theme"`
+
+	// DefaultLanguage is a code of the language of pages that don't
+	// specify one. Default is "en".
+	DefaultLanguage string `yaml:"default_language"`
+
+	// Languages are languages of the site content by code. Pages in
+	// languages other than the default one are placed under the
+	// language code, e.g. "ru/about/".
+	Languages map[string]*Language `yaml:"languages"`
+
+	// Markdown configures Markdown rendering.
+	Markdown MarkdownConfig `yaml:"markdown"`
+
+	// Generators generate pages from data files.
+	Generators []*Generator `yaml:"generators"`
+
+	// Redirects lists formats of redirects files that are written for
+	// page aliases, besides redirect pages: "netlify" (_redirects),
+	// "nginx" (redirects.nginx.conf with a map block) and "apache"
+	// (.htaccess).
+	Redirects []string `yaml:"redirects"`
+
+	// Menus are site menus by name. Pages add themselves to menus in
+	// frontmatter.
+	Menus map[string]Menu `yaml:"menus"`
+
+	// Fingerprint configures fingerprinting of static files.
+	Fingerprint FingerprintConfig `yaml:"fingerprint"`
+
+	// Pipeline configures bundling of stylesheets and scripts from the
+	// assets directory.
+	Pipeline PipelineConfig `yaml:"pipeline"`
+
+	// Compress configures precompressed output.
+	Compress CompressConfig `yaml:"compress"`
+
+	// Minify configures minifiers.
+	Minify MinifyConfig `yaml:"minify"`
+
+	// Imaging configures processing of images from page bundles.
+	Imaging ImagingConfig `yaml:"imaging"`
+
+	// Related configures related pages.
+	Related RelatedConfig `yaml:"related"`
+
+	// SummaryLength is a number of words in automatic page summaries.
+	// Default is 70.
+	SummaryLength int `yaml:"summary_length"`
+
+	// WordsPerMinute is a reading speed that reading time of pages is
+	// estimated with. Default is 200.
+	WordsPerMinute int `yaml:"words_per_minute"`
+}
+
+// loadConfig loads a site configuration from path. If the file does
+// not exist, an empty configuration is returned.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	if !fileutil.Exists(path) {
+		return cfg, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse: %w", path, err)
+	}
+
+	for _, name := range cfg.Redirects {
+		if err := validRedirectFormat(name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.Fingerprint.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.Pipeline.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.Compress.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.Minify.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := cfg.Imaging.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for code := range cfg.Languages {
+		if code == "" || strings.ContainsAny(code, "/.") {
+			return nil, fmt.Errorf("%s: invalid language code %q", path, code)
+		}
+	}
+
+	return cfg, nil
+}
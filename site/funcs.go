@@ -0,0 +1,446 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.astrophena.name/gen/version"
+)
+
+// funcs returns functions that are available to templates.
+func (s *Site) funcs() template.FuncMap {
+	return template.FuncMap{
+		"content": func(p *Page) template.HTML {
+			return template.HTML(p.Content)
+		},
+		"year": func() int {
+			return time.Now().Year()
+		},
+		"version": func() string {
+			return version.Version
+		},
+		"partial": s.partial,
+		"i18n":    s.translate,
+
+		// Dates.
+		"now":        time.Now,
+		"dateFormat": dateFormat,
+
+		// Strings and HTML.
+		"markdownify": s.markdownify,
+		"safeHTML":    func(v interface{}) template.HTML { return template.HTML(toString(v)) },
+		"urlize":      urlize,
+		"truncate":    truncate,
+		"jsonify":     jsonify,
+		"default":     defaultValue,
+
+		// Collections.
+		"dict":    dict,
+		"list":    func(v ...interface{}) []interface{} { return v },
+		"first":   first,
+		"where":   where,
+		"sortBy":  sortBy,
+		"groupBy": groupBy,
+
+		// URLs and files.
+		"absURL":   s.absURL,
+		"relURL":   s.relURL,
+		"ref":      s.ref,
+		"relref":   s.relref,
+		"readFile": s.readFile,
+		"asset":    s.asset,
+		"css":      s.css,
+		"js":       s.js,
+
+		// Images.
+		"srcset": imgSrcset,
+	}
+}
+
+// dateFormat formats t according to layout. The t can be a time.Time
+// or a string in RFC 3339 or "2006-01-02" format.
+func dateFormat(layout string, t interface{}) (string, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case *time.Time:
+		return t.Format(layout), nil
+	case string:
+		for _, l := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if tt, err := time.Parse(l, t); err == nil {
+				return tt.Format(layout), nil
+			}
+		}
+		return "", fmt.Errorf("dateFormat: unable to parse date %q", t)
+	}
+	return "", fmt.Errorf("dateFormat: unsupported type %T", t)
+}
+
+// markdownify renders Markdown to HTML. A single paragraph is
+// unwrapped, so the result can be used inline.
+func (s *Site) markdownify(v interface{}) (template.HTML, error) {
+	doc, err := s.md.Render([]byte(toString(v)), s.renderContext(nil))
+	if err != nil {
+		return "", err
+	}
+
+	out := strings.TrimSpace(string(doc.HTML))
+	if strings.HasPrefix(out, "<p>") && strings.HasSuffix(out, "</p>") && strings.Count(out, "<p>") == 1 {
+		out = strings.TrimSuffix(strings.TrimPrefix(out, "<p>"), "</p>")
+	}
+	return template.HTML(out), nil
+}
+
+// urlize converts v to a string that is safe for using in URLs: it's
+// lowercased, and runs of characters other than letters and digits
+// are replaced with a single dash.
+func urlize(v interface{}) string {
+	var (
+		b    strings.Builder
+		dash bool
+	)
+	for _, r := range strings.ToLower(toString(v)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// truncate shortens v to at most n characters, cutting at a word
+// boundary when possible and appending an ellipsis.
+func truncate(n int, v interface{}) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("truncate: negative length %d", n)
+	}
+	r := []rune(toString(v))
+	if len(r) <= n {
+		return string(r), nil
+	}
+
+	s := string(r[:n])
+	if i := strings.LastIndexFunc(s, unicode.IsSpace); i > 0 {
+		s = s[:i]
+	}
+	return strings.TrimRightFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…", nil
+}
+
+// jsonify encodes v as JSON.
+func jsonify(v interface{}) (template.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(b), nil
+}
+
+// defaultValue returns v if it's set (not a zero value) and def
+// otherwise.
+func defaultValue(def, v interface{}) interface{} {
+	if isZero(v) {
+		return def
+	}
+	return v
+}
+
+// dict creates a map from a list of key and value pairs.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: odd number of arguments")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[k] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// first returns the first n elements of a slice.
+func first(n int, list interface{}) (interface{}, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("first: can't iterate over %T", list)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("first: negative number of elements %d", n)
+	}
+	if n > v.Len() {
+		n = v.Len()
+	}
+	return v.Slice(0, n).Interface(), nil
+}
+
+// where returns pages whose key matches the value. It's called either
+// as `where pages key value` or `where pages key op value`, where op is
+// one of ==, !=, <, <=, >, >= and in.
+func where(pages []*Page, key string, args ...interface{}) ([]*Page, error) {
+	var (
+		op    = "=="
+		value interface{}
+	)
+	switch len(args) {
+	case 1:
+		value = args[0]
+	case 2:
+		o, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("where: operator %v is not a string", args[0])
+		}
+		op, value = o, args[1]
+	default:
+		return nil, errors.New("where: wrong number of arguments")
+	}
+
+	var matched []*Page
+	for _, p := range pages {
+		ok, err := match(p.value(key), op, value)
+		if err != nil {
+			return nil, fmt.Errorf("where: %w", err)
+		}
+		if ok {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+func match(v interface{}, op string, value interface{}) (bool, error) {
+	if op == "in" {
+		list := reflect.ValueOf(value)
+		if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+			return false, fmt.Errorf("can't iterate over %T", value)
+		}
+		for i := 0; i < list.Len(); i++ {
+			if compare(v, list.Index(i).Interface()) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	c := compare(v, value)
+	switch op {
+	case "=", "==", "eq":
+		return c == 0, nil
+	case "!=", "<>", "ne":
+		return c != 0, nil
+	case "<", "lt":
+		return c < 0, nil
+	case "<=", "le":
+		return c <= 0, nil
+	case ">", "gt":
+		return c > 0, nil
+	case ">=", "ge":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// sortBy returns a copy of pages sorted by key. The optional order is
+// either "asc" (default) or "desc".
+func sortBy(pages []*Page, key string, order ...string) ([]*Page, error) {
+	desc := false
+	if len(order) > 0 {
+		switch order[0] {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return nil, fmt.Errorf("sortBy: unknown order %q", order[0])
+		}
+	}
+
+	sorted := make([]*Page, len(pages))
+	copy(sorted, pages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		c := compare(sorted[i].value(key), sorted[j].value(key))
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	return sorted, nil
+}
+
+// PageGroup is a group of pages returned by the groupBy template
+// function.
+type PageGroup struct {
+	Key   interface{}
+	Pages []*Page
+}
+
+// groupBy groups pages by key, keeping the order in which keys first
+// appear.
+func groupBy(pages []*Page, key string) []PageGroup {
+	var (
+		groups []PageGroup
+		index  = make(map[string]int)
+	)
+	for _, p := range pages {
+		v := p.value(key)
+		k := fmt.Sprint(v)
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, PageGroup{Key: v})
+		}
+		groups[i].Pages = append(groups[i].Pages, p)
+	}
+	return groups
+}
+
+// absURL returns an absolute URL of path, prefixed with the base URL
+// of the site.
+func (s *Site) absURL(v interface{}) (string, error) {
+	p := toString(v)
+	if u, err := url.Parse(p); err == nil && u.IsAbs() {
+		return p, nil
+	}
+
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("absURL: invalid base_url: %w", err)
+	}
+	base.Path = path.Join("/", base.Path, p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return base.String(), nil
+}
+
+// relURL returns path relative to the host, prefixed with the path of
+// the base URL of the site.
+func (s *Site) relURL(v interface{}) (string, error) {
+	p := toString(v)
+	if u, err := url.Parse(p); err == nil && u.IsAbs() {
+		return p, nil
+	}
+
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("relURL: invalid base_url: %w", err)
+	}
+	rel := path.Join("/", base.Path, p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+	return rel, nil
+}
+
+// readFile returns contents of the file from the site source directory.
+func (s *Site) readFile(name string) (string, error) {
+	p := filepath.Join(s.src, filepath.FromSlash(path.Clean("/"+name)))
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("readFile: %w", err)
+	}
+	return string(b), nil
+}
+
+// toString converts v to a string.
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.String {
+		return rv.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// isZero reports whether v is nil, a zero value or an empty collection.
+func isZero(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
+
+// compare compares a and b, returning -1, 0 or +1. Numbers are
+// compared by value, times chronologically and everything else as
+// strings.
+func compare(a, b interface{}) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	return strings.Compare(toString(a), toString(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		f, err := strconv.ParseFloat(strings.TrimSpace(rv.String()), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,163 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testPages(s *Site) []*Page {
+	return []*Page{
+		{Title: "Banana", URI: "banana/index.html", Template: "post", Date: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), Params: map[string]interface{}{"price": 3}, s: s},
+		{Title: "Apple", URI: "apple/index.html", Template: "post", Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Params: map[string]interface{}{"price": 10}, s: s},
+		{Title: "About", URI: "about/index.html", Template: "page", Params: map[string]interface{}{"price": 1}, s: s},
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "hello.txt"), []byte("Hello!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Site{src: src, cfg: &Config{BaseURL: "https://example.com/blog/"}}
+	md, err := newMarkdown(&s.cfg.Markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.md = md
+	s.pages = testPages(s)
+
+	cases := map[string]struct {
+		tpl  string
+		data interface{}
+		want string
+	}{
+		"dateFormat time": {
+			tpl:  `{{ dateFormat "Jan 2, 2006" .Date }}`,
+			data: s.pages[0],
+			want: "Mar 1, 2021",
+		},
+		"dateFormat string": {
+			tpl:  `{{ dateFormat "02.01.2006" "2021-05-09" }}`,
+			want: "09.05.2021",
+		},
+		"markdownify": {
+			tpl:  `{{ markdownify "*hi*" }}`,
+			want: "<em>hi</em>",
+		},
+		"safeHTML": {
+			tpl:  `{{ safeHTML "<b>bold</b>" }}`,
+			want: "<b>bold</b>",
+		},
+		"dict and list": {
+			tpl:  `{{ $d := dict "a" 1 "b" (list 2 3) }}{{ $d.a }} {{ index $d.b 1 }}`,
+			want: "1 3",
+		},
+		"where": {
+			tpl:  `{{ range where .Site.Pages "Template" "post" }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "Banana Apple ",
+		},
+		"where with operator": {
+			tpl:  `{{ range where .Site.Pages "Params.price" ">=" 3 }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "Banana Apple ",
+		},
+		"where in": {
+			tpl:  `{{ range where .Site.Pages "Title" "in" (list "About" "Apple") }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "Apple About ",
+		},
+		"sortBy": {
+			tpl:  `{{ range sortBy .Site.Pages "Title" }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "About Apple Banana ",
+		},
+		"sortBy desc": {
+			tpl:  `{{ range sortBy .Site.Pages "price" "desc" }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "Apple Banana About ",
+		},
+		"first": {
+			tpl:  `{{ range first 2 (sortBy .Site.Pages "Date" "desc") }}{{ .Title }} {{ end }}`,
+			data: s.pages[0],
+			want: "Banana Apple ",
+		},
+		"groupBy": {
+			tpl:  `{{ range groupBy .Site.Pages "Template" }}{{ .Key }}:{{ len .Pages }} {{ end }}`,
+			data: s.pages[0],
+			want: "post:2 page:1 ",
+		},
+		"absURL": {
+			tpl:  `{{ absURL "css/site.css" }} {{ absURL "https://example.org/" }}`,
+			want: "https://example.com/blog/css/site.css https://example.org/",
+		},
+		"relURL": {
+			tpl:  `{{ relURL "/tags/go/" }}`,
+			want: "/blog/tags/go/",
+		},
+		"urlize": {
+			tpl:  `{{ urlize "Hello, World & Friends!" }}`,
+			want: "hello-world-friends",
+		},
+		"truncate": {
+			tpl:  `{{ truncate 12 "The quick brown fox" }} {{ truncate 20 "Short" }}`,
+			want: "The quick… Short",
+		},
+		"builtin slice": {
+			tpl:  `{{ slice "Hello, world" 0 5 }}`,
+			want: "Hello",
+		},
+		"readFile": {
+			tpl:  `{{ readFile "hello.txt" }}`,
+			want: "Hello!",
+		},
+		"jsonify": {
+			tpl:  `<script>var d = {{ jsonify (dict "a" (list 1 2)) }};</script>`,
+			want: `<script>var d = {"a":[1,2]};</script>`,
+		},
+		"default": {
+			tpl:  `{{ default "none" "" }} {{ default "none" "set" }} {{ default 5 0 }}`,
+			want: "none set 5",
+		},
+		"composition": {
+			tpl:  `{{ range first 1 (where .Site.Pages "Template" "post") }}{{ .Title | urlize | relURL }}{{ end }}`,
+			data: s.pages[0],
+			want: "/blog/banana",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tpl, err := template.New(name).Funcs(s.funcs()).Parse(tc.tpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := tpl.Execute(&buf, tc.data); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := buf.String(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTruncateNegative(t *testing.T) {
+	tpl := template.Must(template.New("truncate").Funcs(template.FuncMap{"truncate": truncate}).Parse(`{{ truncate -1 "text" }}`))
+	if err := tpl.Execute(&bytes.Buffer{}, nil); err == nil {
+		t.Error("expected an error for a negative length")
+	}
+}
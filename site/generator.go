@@ -0,0 +1,194 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DataFormats contains supported data file formats.
+var DataFormats = []string{".csv", ".json", ".yaml", ".yml"}
+
+// Generator generates one page per record of a data file.
+//
+// URI, Title and Description are templates that are executed with
+// the record as data, e.g. "/products/{{ .slug }}/". The record itself
+// is available to page templates as .Params.
+type Generator struct {
+	Data        string `yaml:"data"` // relative to the data directory
+	URI         string `yaml:"uri"`
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Template    string `yaml:"template"`
+}
+
+// generate returns pages generated by g. Every page is a copy of the
+// proto page with fields set from the record.
+func (s *Site) generate(g *Generator, proto *Page) ([]*Page, error) {
+	src := filepath.Join(s.dataDir(), g.Data)
+
+	if g.Title == "" || g.URI == "" {
+		return nil, fmt.Errorf("%s: generator is missing a required parameter (title, uri)", src)
+	}
+
+	records, err := readData(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		uri   = template.New("uri").Option("missingkey=error")
+		title = template.New("title").Option("missingkey=error")
+		desc  = template.New("description").Option("missingkey=error")
+	)
+	for t, text := range map[*template.Template]string{uri: g.URI, title: g.Title, desc: g.Description} {
+		if _, err := t.Parse(text); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+	}
+
+	lang := proto.Lang
+	if lang == "" {
+		lang = s.defaultLanguage()
+	}
+
+	var pages []*Page
+	for i, r := range records {
+		p := &Page{
+			Content:  proto.Content,
+			Headings: append([]*Heading(nil), proto.Headings...),
+			Summary:  proto.Summary,
+			Template: g.Template,
+			Params:   r,
+			s:        s,
+			Lang:     lang,
+			path:     proto.path,
+			key:      proto.key,
+			plain:    proto.plain,
+			bundle:   proto.bundle,
+		}
+
+		for t, field := range map[*template.Template]*string{uri: &p.URI, title: &p.Title, desc: &p.Description} {
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, r); err != nil {
+				return nil, fmt.Errorf("%s: record %d: %w", src, i+1, err)
+			}
+			*field = buf.String()
+		}
+
+		if p.URI == "" {
+			return nil, fmt.Errorf("%s: record %d: generated an empty uri", src, i+1)
+		}
+		p.URI = normalizeURI(s.languageURI(lang, p.URI))
+		if outsideDir(p.URI) {
+			return nil, fmt.Errorf("%s: record %d: uri %s is outside of the output directory", src, i+1, p.URI)
+		}
+		record := fmt.Sprintf("%s: record %d", src, i+1)
+		if other, ok := s.uris[p.URI]; ok {
+			return nil, fmt.Errorf("%s: uri %s is already used by %s", record, p.URI, other)
+		}
+		s.uris[p.URI] = record
+
+		// Pages don't share maps of the prototype.
+		if proto.MetaTags != nil {
+			p.MetaTags = make(map[string]string, len(proto.MetaTags))
+			for k, v := range proto.MetaTags {
+				p.MetaTags[k] = v
+			}
+		}
+
+		if err := s.resolveTemplate(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
+
+		pages = append(pages, p)
+	}
+
+	return pages, nil
+}
+
+// readData reads a data file and returns its records.
+//
+// The first row of a CSV file is treated as a header with field names.
+// JSON and YAML files must contain a list of objects.
+func readData(path string) ([]map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []map[string]interface{}
+
+	switch filepath.Ext(path) {
+	case ".csv":
+		rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(rows) < 1 {
+			return nil, nil
+		}
+		header := rows[0]
+		for _, row := range rows[1:] {
+			r := make(map[string]interface{}, len(header))
+			for i, name := range header {
+				r[strings.TrimSpace(name)] = row[i]
+			}
+			records = append(records, r)
+		}
+	case ".json":
+		if err := json.Unmarshal(b, &records); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &records); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for i, r := range records {
+			records[i] = stringMap(r)
+		}
+	default:
+		return nil, fmt.Errorf("%s: data format is not supported", path)
+	}
+
+	return records, nil
+}
+
+// stringMap converts maps with interface{} keys, produced by the YAML
+// decoder, to maps with string keys, recursively.
+func stringMap(m map[string]interface{}) map[string]interface{} {
+	for k, v := range m {
+		m[k] = stringKeys(v)
+	}
+	return m
+}
+
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprint(k)] = stringKeys(vv)
+		}
+		return m
+	case map[string]interface{}:
+		return stringMap(v)
+	case []interface{}:
+		for i, vv := range v {
+			v[i] = stringKeys(vv)
+		}
+		return v
+	}
+	return v
+}
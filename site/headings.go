@@ -0,0 +1,195 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+)
+
+// HeadingsConfig configures IDs and anchors of headings in Markdown
+// content.
+type HeadingsConfig struct {
+	// Slug is a rule for making heading IDs from heading text:
+	//
+	//  - "github" (default) lowercases text, replaces spaces with dashes
+	//    and drops punctuation, like GitHub does;
+	//  - "urlize" works like the urlize template function;
+	//  - "ascii" works like "urlize", but keeps only ASCII letters and
+	//    digits.
+	//
+	// IDs set explicitly (e.g. "# Intro {#start}") are used instead.
+	// IDs that are already taken get a numeric suffix, e.g. "intro-1".
+	Slug string `yaml:"slug"`
+
+	// Anchors adds self-links to headings.
+	Anchors bool `yaml:"anchors"`
+
+	// AnchorText is a text of self-links. Default is "#".
+	AnchorText string `yaml:"anchor_text"`
+}
+
+// TOCConfig configures the table of contents of pages.
+type TOCConfig struct {
+	// MinDepth is the level of the highest heading to include. Default
+	// is 1.
+	MinDepth int `yaml:"min_depth"`
+
+	// MaxDepth is the level of the deepest heading to include. Default
+	// is 6.
+	MaxDepth int `yaml:"max_depth"`
+}
+
+// Heading is a heading of the page content.
+type Heading struct {
+	Level    int
+	ID       string
+	Title    string // plain text
+	Children []*Heading
+}
+
+var slugRules = map[string]func(string) string{
+	"github": githubSlug,
+	"urlize": func(s string) string { return urlize(s) },
+	"ascii":  asciiSlug,
+}
+
+// headingIDs generates unique heading IDs within a document.
+type headingIDs struct {
+	slug func(string) string
+	auto bool // generate IDs of headings without explicit ones
+	used map[string]bool
+}
+
+func newHeadingIDs(rule string, auto bool) (*headingIDs, error) {
+	if rule == "" {
+		rule = "github"
+	}
+	slug, ok := slugRules[rule]
+	if !ok {
+		return nil, fmt.Errorf("markdown: unknown heading slug rule %q (available: ascii, github, urlize)", rule)
+	}
+	return &headingIDs{slug: slug, auto: auto, used: make(map[string]bool)}, nil
+}
+
+// id returns a unique ID for the heading text, or for the explicitly
+// set ID, if it's not empty. Without automatic IDs it returns an empty
+// string for headings that don't have an explicit ID.
+func (h *headingIDs) id(text, explicit string) string {
+	if explicit == "" && !h.auto {
+		return ""
+	}
+	base := explicit
+	if base == "" {
+		base = h.slug(text)
+	}
+	if base == "" {
+		base = "heading"
+	}
+
+	id := base
+	for i := 1; h.used[id]; i++ {
+		id = fmt.Sprintf("%s-%d", base, i)
+	}
+	h.used[id] = true
+	return id
+}
+
+// anchor returns HTML of the self-link to the heading, if it has an ID.
+func (cfg *HeadingsConfig) anchor(id string) string {
+	if !cfg.Anchors || id == "" {
+		return ""
+	}
+	text := cfg.AnchorText
+	if text == "" {
+		text = "#"
+	}
+	return fmt.Sprintf(` <a class="anchor" href="#%s" aria-hidden="true">%s</a>`, html.EscapeString(id), html.EscapeString(text))
+}
+
+func githubSlug(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+func asciiSlug(s string) string {
+	return urlize(strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return ' '
+		}
+		return r
+	}, s))
+}
+
+// headingTree arranges headings into a tree, leaving out headings
+// outside of depth limits.
+func headingTree(headings []*Heading, cfg TOCConfig) []*Heading {
+	min, max := cfg.MinDepth, cfg.MaxDepth
+	if min < 1 {
+		min = 1
+	}
+	if max < 1 || max > 6 {
+		max = 6
+	}
+
+	var (
+		roots []*Heading
+		stack []*Heading
+	)
+	for _, h := range headings {
+		if h.Level < min || h.Level > max {
+			continue
+		}
+		h := &Heading{Level: h.Level, ID: h.ID, Title: h.Title}
+
+		for len(stack) > 0 && stack[len(stack)-1].Level >= h.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, h)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, h)
+		}
+		stack = append(stack, h)
+	}
+	return roots
+}
+
+// tableOfContents renders headings as nested lists of links.
+func tableOfContents(headings []*Heading) template.HTML {
+	if len(headings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(`<nav class="toc">`)
+	writeTOCList(&b, headings)
+	b.WriteString(`</nav>`)
+	return template.HTML(b.String())
+}
+
+func writeTOCList(b *strings.Builder, headings []*Heading) {
+	b.WriteString("<ul>")
+	for _, h := range headings {
+		fmt.Fprintf(b, `<li><a href="#%s">%s</a>`, html.EscapeString(h.ID), html.EscapeString(h.Title))
+		if len(h.Children) > 0 {
+			writeTOCList(b, h.Children)
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+}
@@ -0,0 +1,262 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// DefaultHighlightStyle is a name of the syntax highlighting style that
+// is used when none is configured.
+const DefaultHighlightStyle = "github"
+
+// HighlightConfig configures syntax highlighting of fenced code blocks.
+//
+// Attributes of a code block can be set after its language:
+//
+//	```go {hl_lines=[3,"5-7"] linenos=true linenostart=10}
+//
+// Attributes that the highlighter doesn't use are ignored and passed
+// to the code block render hook.
+type HighlightConfig struct {
+	// Enabled enables syntax highlighting.
+	Enabled bool `yaml:"enabled"`
+
+	// Style is a name of the highlighting style, e.g. "monokai".
+	Style string `yaml:"style"`
+
+	// Classes makes highlighted code use CSS classes instead of inline
+	// styles. The stylesheet is generated by the "gen gen-css" command.
+	Classes bool `yaml:"classes"`
+
+	// LineNumbers adds line numbers to all code blocks.
+	LineNumbers bool `yaml:"line_numbers"`
+
+	// LineNumbersInTable puts line numbers into a separate table
+	// column, so they are not copied with the code.
+	LineNumbersInTable bool `yaml:"line_numbers_in_table"`
+
+	// TabWidth is a number of spaces a tab is expanded to.
+	TabWidth int `yaml:"tab_width"`
+}
+
+// highlighter highlights code with Chroma.
+type highlighter struct {
+	cfg   HighlightConfig
+	style *chroma.Style
+}
+
+// newHighlighter returns a highlighter configured by cfg, or nil if
+// highlighting is disabled.
+func newHighlighter(cfg HighlightConfig) (*highlighter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	style, err := highlightStyle(cfg.Style)
+	if err != nil {
+		return nil, err
+	}
+
+	return &highlighter{cfg: cfg, style: style}, nil
+}
+
+func highlightStyle(name string) (*chroma.Style, error) {
+	if name == "" {
+		name = DefaultHighlightStyle
+	}
+
+	style, ok := styles.Registry[name]
+	if !ok {
+		return nil, fmt.Errorf("highlight: unknown style %q (available: %s)", name, strings.Join(styles.Names(), ", "))
+	}
+	return style, nil
+}
+
+// highlight writes highlighted code to w. The info is an info string of
+// the fenced code block: a language optionally followed by attributes.
+// It returns false, doing nothing, for code blocks without a language or
+// in an unknown one.
+func (h *highlighter) highlight(w io.Writer, code, info string) (bool, error) {
+	lang, attrs, err := parseCodeInfo(info)
+	if err != nil {
+		return false, err
+	}
+	if lang == "" {
+		return false, nil
+	}
+
+	// Code in unknown languages, such as diagrams rendered by scripts,
+	// keeps its language class.
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return false, nil
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	opts := []chromahtml.Option{
+		chromahtml.WithClasses(h.cfg.Classes),
+		chromahtml.WithLineNumbers(h.cfg.LineNumbers),
+		chromahtml.LineNumbersInTable(h.cfg.LineNumbersInTable),
+	}
+	if h.cfg.TabWidth > 0 {
+		opts = append(opts, chromahtml.TabWidth(h.cfg.TabWidth))
+	}
+
+	// Other attributes, such as title, are left to render hooks.
+	// Options are applied in the same order every time, so the output
+	// is stable.
+	for _, key := range sortedKeys(attrs) {
+		value := attrs[key]
+		switch key {
+		case "hl_lines":
+			ranges, err := parseLineRanges(value)
+			if err != nil {
+				return false, err
+			}
+			opts = append(opts, chromahtml.HighlightLines(ranges))
+		case "linenos":
+			switch value {
+			case "true", "inline":
+				opts = append(opts, chromahtml.WithLineNumbers(true), chromahtml.LineNumbersInTable(false))
+			case "table":
+				opts = append(opts, chromahtml.WithLineNumbers(true), chromahtml.LineNumbersInTable(true))
+			case "false":
+				opts = append(opts, chromahtml.WithLineNumbers(false))
+			default:
+				return false, fmt.Errorf("highlight: invalid linenos value %q", value)
+			}
+		case "linenostart":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return false, fmt.Errorf("highlight: invalid linenostart value %q", value)
+			}
+			opts = append(opts, chromahtml.BaseLineNumber(n))
+		}
+	}
+
+	it, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		return false, err
+	}
+
+	if err := chromahtml.New(opts...).Format(w, h.style, it); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// parseCodeInfo parses an info string of the fenced code block, such
+// as `go {hl_lines=[3,5] linenos=true}`.
+func parseCodeInfo(info string) (lang string, attrs map[string]string, err error) {
+	info = strings.TrimSpace(info)
+	attrs = make(map[string]string)
+
+	start := strings.IndexByte(info, '{')
+	if start < 0 {
+		if f := strings.Fields(info); len(f) > 0 {
+			lang = f[0]
+		}
+		return lang, attrs, nil
+	}
+	if f := strings.Fields(info[:start]); len(f) > 0 {
+		lang = f[0]
+	}
+
+	end := strings.LastIndexByte(info, '}')
+	if end < start {
+		return "", nil, fmt.Errorf("highlight: unterminated attributes in %q", info)
+	}
+
+	r := []rune(info[start+1 : end])
+	for i := 0; i < len(r); {
+		if unicode.IsSpace(r[i]) || r[i] == ',' {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(r) && r[j] != '=' && !unicode.IsSpace(r[j]) {
+			j++
+		}
+		key := string(r[i:j])
+		if j >= len(r) || r[j] != '=' {
+			return "", nil, fmt.Errorf("highlight: attribute %q has no value", key)
+		}
+		i = j + 1
+
+		var value string
+		switch {
+		case i < len(r) && (r[i] == '[' || r[i] == '"'):
+			closing := ']'
+			if r[i] == '"' {
+				closing = '"'
+			}
+			j = i + 1
+			for j < len(r) && r[j] != closing {
+				j++
+			}
+			if j >= len(r) {
+				return "", nil, fmt.Errorf("highlight: unterminated value of attribute %q", key)
+			}
+			value, i = string(r[i+1:j]), j+1
+		default:
+			j = i
+			for j < len(r) && !unicode.IsSpace(r[j]) && r[j] != ',' {
+				j++
+			}
+			value, i = string(r[i:j]), j
+		}
+		attrs[key] = value
+	}
+
+	return lang, attrs, nil
+}
+
+// parseLineRanges parses line numbers and ranges, such as `3, "5-7"`.
+func parseLineRanges(s string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '"' || unicode.IsSpace(r)
+	}) {
+		var (
+			from, to int
+			err      error
+		)
+		if i := strings.IndexByte(f, '-'); i > 0 {
+			from, err = strconv.Atoi(f[:i])
+			if err == nil {
+				to, err = strconv.Atoi(f[i+1:])
+			}
+		} else {
+			from, err = strconv.Atoi(f)
+			to = from
+		}
+		if err != nil {
+			return nil, fmt.Errorf("highlight: invalid line range %q", f)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+// WriteHighlightCSS writes a stylesheet of the highlighting style to w.
+// It's used with the classes option of syntax highlighting.
+func WriteHighlightCSS(w io.Writer, style string) error {
+	s, err := highlightStyle(style)
+	if err != nil {
+		return err
+	}
+	return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, s)
+}
@@ -0,0 +1,120 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"html/template"
+	"io"
+	"path"
+	"strings"
+)
+
+// RenderHooksDir is a directory inside the templates directory that
+// contains templates overriding how Markdown nodes are rendered:
+//
+//   - link.tmpl and image.tmpl receive a RenderLink;
+//   - heading.tmpl receives a RenderHeading;
+//   - codeblock.tmpl, or codeblock-<lang>.tmpl for code blocks in the
+//     language lang (e.g. codeblock-mermaid.tmpl), receive a
+//     RenderCodeBlock.
+const RenderHooksDir = "render-hooks"
+
+// RenderLink is a link or an image passed to a render hook.
+type RenderLink struct {
+	Destination string
+	Title       string
+	Text        template.HTML // rendered link text or image alt text
+	PlainText   string
+	Page        *Page // nil when rendered outside of a page, e.g. by markdownify
+}
+
+// RenderHeading is a heading passed to a render hook.
+type RenderHeading struct {
+	Level     int
+	ID        string
+	Text      template.HTML
+	PlainText string
+	Page      *Page
+}
+
+// RenderCodeBlock is a fenced code block passed to a render hook.
+type RenderCodeBlock struct {
+	Type       string            // language of the code block
+	Attributes map[string]string // attributes after the language, e.g. {title="main.go"}
+	Inner      string            // code
+	Page       *Page
+}
+
+// renderContext is a context of rendering Markdown content.
+type renderContext struct {
+	s     *Site
+	page  *Page // nil when rendered outside of a page, e.g. by markdownify
+	hooks bool  // the site has render hooks
+}
+
+// renderContext returns a context for rendering content of the page p.
+func (s *Site) renderContext(p *Page) *renderContext {
+	ctx := &renderContext{s: s, page: p}
+	if s.tpl != nil {
+		for _, t := range s.tpl.Templates() {
+			if strings.HasPrefix(t.Name(), RenderHooksDir+"/") {
+				ctx.hooks = true
+				break
+			}
+		}
+	}
+	return ctx
+}
+
+// hasHooks reports whether the site has render hooks.
+func (ctx *renderContext) hasHooks() bool {
+	return ctx != nil && ctx.hooks
+}
+
+// lookupHook returns a name of the first defined render hook out of
+// names.
+func (ctx *renderContext) lookupHook(names ...string) (string, bool) {
+	if !ctx.hasHooks() {
+		return "", false
+	}
+	for _, name := range names {
+		name = path.Join(RenderHooksDir, name)
+		if ctx.s.tpl.Lookup(name) != nil {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// executeHook executes the render hook template name with data.
+func (ctx *renderContext) executeHook(w io.Writer, name string, data interface{}) error {
+	return ctx.s.tpl.ExecuteTemplate(w, name, data)
+}
+
+// resolveLink returns the link destination with links to page source
+// files replaced by page URLs.
+func (ctx *renderContext) resolveLink(dest string) (string, error) {
+	if ctx == nil {
+		return dest, nil
+	}
+	link, err := ctx.s.resolveLink(ctx.page, dest)
+	if err != nil || link == dest || ctx.page == nil {
+		return link, err
+	}
+
+	// Broken links are searched in the page source file by what was
+	// written there.
+	p := ctx.page
+	if p.links == nil {
+		p.links = make(map[string][]string)
+	}
+	for _, d := range p.links[link] {
+		if d == dest {
+			return link, nil
+		}
+	}
+	p.links[link] = append(p.links[link], dest)
+	return link, nil
+}
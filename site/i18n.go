@@ -0,0 +1,268 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+)
+
+// I18nDir is a directory with translations of template strings. It
+// can be provided by both the site and its themes.
+const I18nDir = "i18n"
+
+// DefaultLanguage is a code of the site language that is used when
+// none is configured.
+const DefaultLanguage = "en"
+
+// Language is a language of the site content.
+type Language struct {
+	Code   string `yaml:"-"`
+	Name   string `yaml:"name"`   // e.g. "Русский"
+	Weight int    `yaml:"weight"` // orders languages
+
+	// Menus override site menus for pages in the language.
+	Menus map[string]Menu `yaml:"menus"`
+}
+
+// Languages returns languages of the site, ordered by weight and code.
+func (s *Site) Languages() []*Language {
+	var langs []*Language
+	for code, l := range s.cfg.Languages {
+		if l == nil {
+			l = &Language{}
+		}
+		l.Code = code
+		langs = append(langs, l)
+	}
+	if _, ok := s.cfg.Languages[s.defaultLanguage()]; !ok {
+		langs = append(langs, &Language{Code: s.defaultLanguage()})
+	}
+
+	sort.Slice(langs, func(i, j int) bool {
+		if langs[i].Weight != langs[j].Weight {
+			return lessWeight(langs[i].Weight, langs[j].Weight)
+		}
+		return langs[i].Code < langs[j].Code
+	})
+	return langs
+}
+
+// Language returns the language of the site view, that is the language
+// of the page being built.
+func (s *Site) Language() *Language {
+	if s.lang == "" {
+		return s.language(s.defaultLanguage())
+	}
+	return s.language(s.lang)
+}
+
+// language returns the site language by code, or nil.
+func (s *Site) language(code string) *Language {
+	for _, l := range s.Languages() {
+		if l.Code == code {
+			return l
+		}
+	}
+	return nil
+}
+
+func (s *Site) defaultLanguage() string {
+	if s.cfg.DefaultLanguage != "" {
+		return s.cfg.DefaultLanguage
+	}
+	return DefaultLanguage
+}
+
+// pageLanguage returns a language of the page source file rel and a key
+// that is shared by its translations. The language is set by the
+// top-level directory (ru/post.md) or by the file name (post.ru.md).
+func (s *Site) pageLanguage(rel string) (lang, key string) {
+	if i := strings.IndexByte(rel, '/'); i > 0 && s.language(rel[:i]) != nil {
+		return rel[:i], rel[i+1:]
+	}
+
+	ext := path.Ext(rel)
+	stem := strings.TrimSuffix(rel, ext)
+	if code := strings.TrimPrefix(path.Ext(stem), "."); code != "" && s.language(code) != nil {
+		return code, strings.TrimSuffix(stem, "."+code) + ext
+	}
+
+	return s.defaultLanguage(), rel
+}
+
+// languageURI prefixes uri with the language code for languages other
+// than the default one.
+func (s *Site) languageURI(lang, uri string) string {
+	if lang == s.defaultLanguage() {
+		return uri
+	}
+	return lang + "/" + strings.TrimPrefix(uri, "/")
+}
+
+// Language returns the language of the page.
+func (p *Page) Language() *Language { return p.s.language(p.Lang) }
+
+// Translations returns the page in other languages, ordered by language
+// weight.
+func (p *Page) Translations() []*Page {
+	if p.key == "" {
+		return nil
+	}
+
+	var pages []*Page
+	for _, l := range p.s.Languages() {
+		if t := p.translation(l.Code); t != nil && t != p {
+			pages = append(pages, t)
+		}
+	}
+	return pages
+}
+
+// translation returns the page in the language lang, or nil.
+func (p *Page) translation(lang string) *Page {
+	if p.s == nil || p.key == "" {
+		return nil
+	}
+	for _, t := range p.s.translations[p.key] {
+		if t.Lang == lang {
+			return t
+		}
+	}
+	return nil
+}
+
+// buildLanguages splits pages between language sites, that have their
+// own pages, menus and page relations.
+func (s *Site) buildLanguages() error {
+	s.views = make(map[string]*Site)
+	for _, l := range s.Languages() {
+		v := *s
+		cfg := *s.cfg
+		if len(l.Menus) > 0 {
+			cfg.Menus = l.Menus
+		}
+		v.cfg = &cfg
+		v.lang = l.Code
+
+		v.pages = nil
+		for _, p := range s.pages {
+			if p.Lang == l.Code {
+				v.pages = append(v.pages, p)
+			}
+		}
+
+		v.linkPages()
+		v.buildTree()
+		v.buildRelated()
+		if err := v.buildMenus(); err != nil {
+			if len(s.cfg.Languages) > 0 {
+				return fmt.Errorf("language %s: %w", l.Code, err)
+			}
+			return err
+		}
+
+		s.views[l.Code] = &v
+	}
+
+	s.menus = s.views[s.defaultLanguage()].menus
+	return nil
+}
+
+// loadI18n loads translations of template strings from i18n directories
+// of the site and its themes. Files are named after language codes,
+// e.g. ru.yaml. Site strings override theme ones.
+func (s *Site) loadI18n() error {
+	s.i18n = make(map[string]map[string]interface{})
+
+	dirs := s.lookupDirs(I18nDir)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		for _, l := range s.Languages() {
+			file := filepath.Join(dirs[i], l.Code+".yaml")
+			b, err := os.ReadFile(file)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			var strs map[string]interface{}
+			if err := yaml.Unmarshal(b, &strs); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			if s.i18n[l.Code] == nil {
+				s.i18n[l.Code] = make(map[string]interface{})
+			}
+			for k, v := range strs {
+				s.i18n[l.Code][k] = v
+			}
+		}
+	}
+
+	return nil
+}
+
+// translate returns the string key translated to the language of the
+// page being built, falling back to the default language and then to
+// the key itself.
+//
+// A translation is a template that is executed with data. It can have
+// plural forms, "one" and "other", that are chosen by data:
+//
+//	minutes:
+//	  one: "{{ . }} minute"
+//	  other: "{{ . }} minutes"
+func (s *Site) translate(key string, data ...interface{}) (string, error) {
+	if len(data) > 1 {
+		return "", fmt.Errorf("i18n %s: too many arguments", key)
+	}
+	var d interface{}
+	if len(data) == 1 {
+		d = data[0]
+	}
+
+	lang := s.curLang
+	if lang == "" {
+		lang = s.defaultLanguage()
+	}
+	v, ok := s.i18n[lang][key]
+	if !ok {
+		v, ok = s.i18n[s.defaultLanguage()][key]
+	}
+	if !ok {
+		return key, nil
+	}
+
+	var text string
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		form := "other"
+		if n, ok := toFloat(d); ok && n == 1 {
+			form = "one"
+		}
+		text = toString(v[form])
+	default:
+		text = toString(v)
+	}
+
+	t, err := template.New(key).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("i18n %s: %w", key, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("i18n %s: %w", key, err)
+	}
+	return buf.String(), nil
+}
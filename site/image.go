@@ -0,0 +1,626 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+
+	"golang.org/x/image/draw"
+)
+
+// Defaults of image processing.
+const (
+	DefaultImageQuality  = 75
+	DefaultImageFilter   = "catmullrom"
+	DefaultImageAnchor   = "center"
+	DefaultImageCacheDir = "resources"
+)
+
+// ImagingConfig configures image processing.
+type ImagingConfig struct {
+	// Quality is a quality of JPEG images, from 1 to 100. Default is
+	// 75.
+	Quality int `yaml:"quality"`
+
+	// Filter is a resampling filter: "nearest", "approxbilinear",
+	// "bilinear" or "catmullrom" (the default, slowest and sharpest).
+	Filter string `yaml:"filter"`
+
+	// Anchor is a part of the image that is kept by Fill and Crop:
+	// "center" (the default), "top", "bottom", "left", "right",
+	// "topleft", "topright", "bottomleft" or "bottomright".
+	Anchor string `yaml:"anchor"`
+
+	// CacheDir is a directory of processed images, relative to the site
+	// directory. Default is "resources".
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// validate reports whether the configuration has unknown filter or
+// anchor.
+func (c *ImagingConfig) validate() error {
+	if c.Quality < 0 || c.Quality > 100 {
+		return fmt.Errorf("imaging: quality %d is out of range 1-100", c.Quality)
+	}
+	if _, ok := imageFilters[c.Filter]; c.Filter != "" && !ok {
+		return fmt.Errorf("imaging: unknown filter %s", c.Filter)
+	}
+	if _, ok := imageAnchors[c.Anchor]; c.Anchor != "" && !ok {
+		return fmt.Errorf("imaging: unknown anchor %s", c.Anchor)
+	}
+	return nil
+}
+
+var imageFilters = map[string]draw.Interpolator{
+	"nearest":        draw.NearestNeighbor,
+	"approxbilinear": draw.ApproxBiLinear,
+	"bilinear":       draw.BiLinear,
+	"catmullrom":     draw.CatmullRom,
+}
+
+// imageAnchors are anchor points as fractions of the free space on the
+// left and on the top.
+var imageAnchors = map[string][2]float64{
+	"center":      {0.5, 0.5},
+	"top":         {0.5, 0},
+	"bottom":      {0.5, 1},
+	"left":        {0, 0.5},
+	"right":       {1, 0.5},
+	"topleft":     {0, 0},
+	"topright":    {1, 0},
+	"bottomleft":  {0, 1},
+	"bottomright": {1, 1},
+}
+
+var imageFormats = map[string]string{
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// imageOptions are options of image processing, parsed from a spec like
+// "800x600 q90 bilinear top png".
+type imageOptions struct {
+	op            string
+	width, height int
+	quality       int
+	filter        string
+	anchor        string
+	mediaType     string
+}
+
+func (s *Site) parseImageOptions(op, spec string, mediaType string) (*imageOptions, error) {
+	o := &imageOptions{
+		op:        op,
+		quality:   s.cfg.Imaging.Quality,
+		filter:    s.cfg.Imaging.Filter,
+		anchor:    s.cfg.Imaging.Anchor,
+		mediaType: mediaType,
+	}
+	if o.quality == 0 {
+		o.quality = DefaultImageQuality
+	}
+	if o.filter == "" {
+		o.filter = DefaultImageFilter
+	}
+	if o.anchor == "" {
+		o.anchor = DefaultImageAnchor
+	}
+
+	fields := strings.Fields(strings.ToLower(spec))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing dimensions")
+	}
+
+	dims := strings.SplitN(fields[0], "x", 2)
+	if len(dims) != 2 {
+		return nil, fmt.Errorf("invalid dimensions %s, want WIDTHxHEIGHT", fields[0])
+	}
+	for i, d := range dims {
+		if d == "" {
+			continue
+		}
+		n, err := strconv.Atoi(d)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid dimensions %s, want WIDTHxHEIGHT", fields[0])
+		}
+		if i == 0 {
+			o.width = n
+		} else {
+			o.height = n
+		}
+	}
+	if o.width == 0 && o.height == 0 {
+		return nil, fmt.Errorf("invalid dimensions %s, want WIDTHxHEIGHT", fields[0])
+	}
+	if op != "resize" && (o.width == 0 || o.height == 0) {
+		return nil, fmt.Errorf("%s needs both width and height", op)
+	}
+
+	for _, f := range fields[1:] {
+		if _, ok := imageFilters[f]; ok {
+			o.filter = f
+			continue
+		}
+		if _, ok := imageAnchors[f]; ok {
+			o.anchor = f
+			continue
+		}
+		if typ, ok := imageFormats[f]; ok {
+			o.mediaType = typ
+			continue
+		}
+		if strings.HasPrefix(f, "q") {
+			if q, err := strconv.Atoi(f[1:]); err == nil && q >= 1 && q <= 100 {
+				o.quality = q
+				continue
+			}
+		}
+		return nil, fmt.Errorf("unknown option %s", f)
+	}
+
+	return o, nil
+}
+
+// key returns a string that identifies the options.
+func (o *imageOptions) key() string {
+	return fmt.Sprintf("%s %dx%d q%d %s %s %s", o.op, o.width, o.height, o.quality, o.filter, o.anchor, o.mediaType)
+}
+
+// Resize returns the image resized to spec, "WIDTHxHEIGHT" followed by
+// options: quality ("q90"), filter, and format ("jpg", "png" or "gif").
+// A missing width or height keeps the aspect ratio.
+func (r *Resource) Resize(spec string) (*Resource, error) { return r.processImage("resize", spec) }
+
+// Fit returns the image scaled down to fit in "WIDTHxHEIGHT", keeping
+// the aspect ratio. Options are those of Resize.
+func (r *Resource) Fit(spec string) (*Resource, error) { return r.processImage("fit", spec) }
+
+// Fill returns the image scaled and cropped to fill "WIDTHxHEIGHT".
+// Besides options of Resize, an anchor is a part of the image to keep,
+// e.g. "top".
+func (r *Resource) Fill(spec string) (*Resource, error) { return r.processImage("fill", spec) }
+
+// Crop returns the "WIDTHxHEIGHT" part of the image, without scaling.
+// Options are those of Fill.
+func (r *Resource) Crop(spec string) (*Resource, error) { return r.processImage("crop", spec) }
+
+// Width returns a width of the image in pixels.
+func (r *Resource) Width() (int, error) {
+	if err := r.decodeConfig(); err != nil {
+		return 0, err
+	}
+	return r.width, nil
+}
+
+// Height returns a height of the image in pixels.
+func (r *Resource) Height() (int, error) {
+	if err := r.decodeConfig(); err != nil {
+		return 0, err
+	}
+	return r.height, nil
+}
+
+func (r *Resource) decodeConfig() error {
+	if r.width != 0 {
+		return nil
+	}
+	b, err := os.ReadFile(r.src)
+	if err != nil {
+		return err
+	}
+	c, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.Name, err)
+	}
+	r.width, r.height = c.Width, c.Height
+	// Images are processed in the orientation they are displayed in.
+	if format == "jpeg" && jpegOrientation(b) >= 5 {
+		r.width, r.height = r.height, r.width
+	}
+	return nil
+}
+
+// processImage returns the image processed by op and writes it to the
+// output directory. Processed images are cached in the cache directory
+// by a hash of the source image and options.
+func (r *Resource) processImage(op, spec string) (*Resource, error) {
+	if _, ok := imageFormatOf(r.MediaType); !ok {
+		return nil, fmt.Errorf("%s: %s: not a JPEG, PNG or GIF image", op, r.Name)
+	}
+	o, err := r.s.parseImageOptions(op, spec, r.MediaType)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s: %w", op, spec, r.Name, err)
+	}
+
+	b, err := os.ReadFile(r.src)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(b)
+	h.Write([]byte(o.key()))
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	ext, _ := imageFormatOf(o.mediaType)
+	cacheDir := r.s.cfg.Imaging.CacheDir
+	if cacheDir == "" {
+		cacheDir = DefaultImageCacheDir
+	}
+	cached := filepath.Join(r.s.src, cacheDir, hash+ext)
+
+	if !fileutil.Exists(cached) {
+		if err := processImageFile(b, cached, o); err != nil {
+			return nil, fmt.Errorf("%s %s: %s: %w", op, spec, r.Name, err)
+		}
+	}
+
+	name := strings.TrimSuffix(r.Name, path.Ext(r.Name)) + "_" + hash[:10] + ext
+	pr := &Resource{
+		Name:      name,
+		MediaType: o.mediaType,
+		src:       cached,
+		dst:       path.Join(path.Dir(r.dst), path.Base(name)),
+		s:         r.s,
+	}
+	if pr.URL, err = r.s.relURL(pr.dst); err != nil {
+		return nil, err
+	}
+	if pr.Permalink, err = r.s.absURL(pr.dst); err != nil {
+		return nil, err
+	}
+
+	dst := filepath.Join(r.s.dst, filepath.FromSlash(pr.dst))
+	if err := fileutil.Mkdir(filepath.Dir(dst)); err != nil {
+		return nil, err
+	}
+	if err := fileutil.CopyFile(cached, dst); err != nil {
+		return nil, err
+	}
+
+	return pr, nil
+}
+
+// processImageFile decodes the image b, processes it with o and writes
+// the result to the file dst. JPEG images are rotated according to their
+// EXIF orientation first. All frames of animated GIF images are
+// processed, unless they are converted to another format; then only the
+// first frame is.
+func processImageFile(b []byte, dst string, o *imageOptions) error {
+	_, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if format == "gif" && o.mediaType == "image/gif" {
+		g, err := gif.DecodeAll(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if err := gif.EncodeAll(&buf, resampleGIF(g, o)); err != nil {
+			return err
+		}
+	} else {
+		src, _, err := image.Decode(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if format == "jpeg" {
+			src = orientImage(src, jpegOrientation(b))
+		}
+
+		img := resample(src, o)
+		switch o.mediaType {
+		case "image/jpeg":
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: o.quality})
+		case "image/png":
+			err = png.Encode(&buf, img)
+		case "image/gif":
+			err = gif.Encode(&buf, img, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fileutil.Mkdir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
+
+// resample returns the image src processed with o.
+func resample(src image.Image, o *imageOptions) *image.RGBA {
+	var (
+		sb           = src.Bounds()
+		sw, sh       = sb.Dx(), sb.Dy()
+		w, h         = o.width, o.height
+		srcRect      = sb
+		anchor       = imageAnchors[o.anchor]
+		filter       = imageFilters[o.filter]
+		crop         bool
+		fw, fh       = float64(sw), float64(sh)
+		ratio        = fw / fh
+		anchoredRect = func(cw, ch int) image.Rectangle {
+			x := sb.Min.X + int(float64(sw-cw)*anchor[0])
+			y := sb.Min.Y + int(float64(sh-ch)*anchor[1])
+			return image.Rect(x, y, x+cw, y+ch)
+		}
+	)
+
+	switch o.op {
+	case "resize":
+		if w == 0 {
+			w = int(float64(h)*ratio + 0.5)
+		}
+		if h == 0 {
+			h = int(float64(w)/ratio + 0.5)
+		}
+	case "fit":
+		scale := minFloat(float64(w)/fw, float64(h)/fh)
+		if scale > 1 {
+			scale = 1
+		}
+		w, h = int(fw*scale+0.5), int(fh*scale+0.5)
+	case "fill":
+		// Crop the source to the aspect ratio of the result first.
+		if float64(w)/float64(h) > ratio {
+			srcRect = anchoredRect(sw, int(fw*float64(h)/float64(w)+0.5))
+		} else {
+			srcRect = anchoredRect(int(fh*float64(w)/float64(h)+0.5), sh)
+		}
+	case "crop":
+		if w > sw {
+			w = sw
+		}
+		if h > sh {
+			h = sh
+		}
+		srcRect = anchoredRect(w, h)
+		crop = true
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	if crop {
+		draw.Draw(img, img.Bounds(), src, srcRect.Min, draw.Src)
+	} else {
+		filter.Scale(img, img.Bounds(), src, srcRect, draw.Src, nil)
+	}
+	return img
+}
+
+// resampleGIF returns the animated GIF image g with every frame
+// processed with o. Frames are drawn over previous ones as a browser
+// would, so the processed frames cover the whole image.
+func resampleGIF(g *gif.GIF, o *imageOptions) *gif.GIF {
+	out := &gif.GIF{
+		Delay:     g.Delay,
+		LoopCount: g.LoopCount,
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			draw.Draw(previous, previous.Bounds(), canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		img := resample(canvas, o)
+		pm := image.NewPaletted(img.Bounds(), frame.Palette)
+		draw.FloydSteinberg.Draw(pm, pm.Bounds(), img, image.Point{})
+		out.Image = append(out.Image, pm)
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+
+		switch {
+		case previous != nil:
+			canvas = previous
+		case i < len(g.Disposal) && g.Disposal[i] == gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return out
+}
+
+// jpegOrientation returns the EXIF orientation of the JPEG image b, from
+// 1 to 8, or 1 if it has none.
+func jpegOrientation(b []byte) int {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(b) && b[i] == 0xFF; {
+		marker := b[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			break
+		}
+		size := int(binary.BigEndian.Uint16(b[i+2:]))
+		if size < 2 || i+2+size > len(b) {
+			break
+		}
+		if seg := b[i+4 : i+2+size]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation tag of the first IFD of the
+// EXIF data b, or 1 if there's none.
+func exifOrientation(b []byte) int {
+	if len(b) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(b[4:]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return 1
+	}
+	for i, n := 0, int(order.Uint16(b[ifd:])); i < n; i++ {
+		e := ifd + 2 + i*12
+		if e+12 > len(b) {
+			break
+		}
+		if order.Uint16(b[e:]) == 0x0112 {
+			if v := int(order.Uint16(b[e+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orientImage returns the image src flipped and rotated as the EXIF
+// orientation says it should be displayed.
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	w, h := sw, sh
+	if orientation >= 5 {
+		w, h = sh, sw
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = sw-1-x, y
+			case 3: // rotate by 180°
+				sx, sy = sw-1-x, sh-1-y
+			case 4: // flip vertically
+				sx, sy = x, sh-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate by 90° clockwise
+				sx, sy = y, sh-1-x
+			case 7: // transverse
+				sx, sy = sw-1-y, sh-1-x
+			case 8: // rotate by 90° counterclockwise
+				sx, sy = sw-1-y, x
+			}
+			img.Set(x, y, src.At(sb.Min.X+sx, sb.Min.Y+sy))
+		}
+	}
+	return img
+}
+
+// imageFormatOf returns a file extension of images of the media type
+// and reports whether images of the type can be processed.
+func imageFormatOf(mediaType string) (string, bool) {
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg", true
+	case "image/png":
+		return ".png", true
+	case "image/gif":
+		return ".gif", true
+	}
+	return "", false
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// imgSrcset returns an <img> element for the image with srcset of the
+// image resized to widths. Widths above the image width are replaced
+// by the image itself. The src, width and height attributes are of the
+// widest image.
+func imgSrcset(img *Resource, alt string, widths ...int) (template.HTML, error) {
+	if img == nil {
+		return "", fmt.Errorf("srcset: no image")
+	}
+	if len(widths) == 0 {
+		return "", fmt.Errorf("srcset: no widths")
+	}
+	iw, err := img.Width()
+	if err != nil {
+		return "", fmt.Errorf("srcset: %w", err)
+	}
+
+	sort.Ints(widths)
+	var (
+		srcset  []string
+		largest = img
+		seen    = make(map[int]bool)
+	)
+	for _, w := range widths {
+		if w <= 0 {
+			return "", fmt.Errorf("srcset: invalid width %d", w)
+		}
+		src := img
+		if w >= iw {
+			w = iw
+		} else {
+			if src, err = img.Resize(strconv.Itoa(w) + "x"); err != nil {
+				return "", fmt.Errorf("srcset: %w", err)
+			}
+		}
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		srcset = append(srcset, fmt.Sprintf("%s %dw", src.URL, w))
+		largest = src
+	}
+
+	lw, err := largest.Width()
+	if err != nil {
+		return "", fmt.Errorf("srcset: %w", err)
+	}
+	lh, err := largest.Height()
+	if err != nil {
+		return "", fmt.Errorf("srcset: %w", err)
+	}
+
+	esc := template.HTMLEscapeString
+	return template.HTML(fmt.Sprintf(`<img src="%s" srcset="%s" width="%d" height="%d" alt="%s">`,
+		esc(largest.URL), esc(strings.Join(srcset, ", ")), lw, lh, esc(alt))), nil
+}
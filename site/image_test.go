@@ -0,0 +1,140 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exifJPEG returns a JPEG image with the EXIF orientation. Its left half
+// is red and its right half is blue.
+func exifJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A big-endian TIFF header with one IFD entry: the orientation.
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	b := buf.Bytes()
+	out := append([]byte{}, b[:2]...)
+	out = append(out, 0xFF, 0xE1, 0, 0)
+	binary.BigEndian.PutUint16(out[4:], uint16(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, b[2:]...)
+}
+
+func TestImageOrientation(t *testing.T) {
+	b := exifJPEG(t, 40, 20, 6)
+	if got := jpegOrientation(b); got != 6 {
+		t.Fatalf("got orientation %d, want 6", got)
+	}
+
+	src := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(src, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := &Resource{Name: "photo.jpg", src: src}
+	if w, err := r.Width(); err != nil || w != 20 {
+		t.Errorf("got width %d, %v, want 20", w, err)
+	}
+	if h, err := r.Height(); err != nil || h != 40 {
+		t.Errorf("got height %d, %v, want 40", h, err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.png")
+	if err := processImageFile(b, dst, &imageOptions{op: "resize", width: 10, filter: "nearest", mediaType: "image/png"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated by 90° clockwise, the left half becomes the top half.
+	if got := img.Bounds().Size(); got != image.Pt(10, 20) {
+		t.Fatalf("got size %v, want 10x20", got)
+	}
+	if r, _, b, _ := img.At(5, 2).RGBA(); r < b {
+		t.Errorf("top is not red")
+	}
+	if r, _, b, _ := img.At(5, 17).RGBA(); b < r {
+		t.Errorf("bottom is not blue")
+	}
+}
+
+func TestAnimatedGIF(t *testing.T) {
+	g := &gif.GIF{LoopCount: 0}
+	for _, c := range []uint8{1, 2, 3} {
+		frame := image.NewPaletted(image.Rect(0, 0, 40, 20), palette.Plan9)
+		for i := range frame.Pix {
+			frame.Pix[i] = c
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10*int(c))
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.gif")
+	if err := processImageFile(buf.Bytes(), dst, &imageOptions{op: "fit", width: 20, height: 20, filter: "nearest", mediaType: "image/gif"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Image) != 3 {
+		t.Fatalf("got %d frames, want 3", len(got.Image))
+	}
+	for i, frame := range got.Image {
+		if size := frame.Bounds().Size(); size != image.Pt(20, 10) {
+			t.Errorf("frame %d: got size %v, want 20x10", i, size)
+		}
+		if got.Delay[i] != g.Delay[i] {
+			t.Errorf("frame %d: got delay %d, want %d", i, got.Delay[i], g.Delay[i])
+		}
+		if want := palette.Plan9[i+1]; frame.At(10, 5) != want {
+			t.Errorf("frame %d: got color %v, want %v", i, frame.At(10, 5), want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// resolveLink returns dest with a link to a Markdown page source file
+// (e.g. "../setup.md#usage") replaced by the page URL. Other links are
+// returned as is.
+func (s *Site) resolveLink(from *Page, dest string) (string, error) {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || path.Ext(u.Path) != ".md" {
+		return dest, nil
+	}
+
+	link, err := s.pageRef(from, u.Path, s.relURL)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery != "" {
+		link += "?" + u.RawQuery
+	}
+	if u.Fragment != "" {
+		link += "#" + u.Fragment
+	}
+	return link, nil
+}
+
+// ref returns the absolute URL of the page with the source file name.
+// A name that doesn't start with a slash is relative to the source file
+// of the page p.
+func (s *Site) ref(p *Page, name string) (string, error) {
+	return s.pageRef(p, name, s.absURL)
+}
+
+// relref is like ref, but returns a URL relative to the host.
+func (s *Site) relref(p *Page, name string) (string, error) {
+	return s.pageRef(p, name, s.relURL)
+}
+
+// pageRef finds the page with the source file name and returns its URL
+// made by urlFunc. A fragment of name is kept.
+func (s *Site) pageRef(from *Page, name string, urlFunc func(interface{}) (string, error)) (string, error) {
+	var fragment string
+	if i := strings.IndexByte(name, '#'); i >= 0 {
+		name, fragment = name[:i], name[i:]
+	}
+
+	p, err := s.sourcePage(from, name)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := urlFunc(uriPath(p.URI))
+	if err != nil {
+		return "", err
+	}
+	return link + fragment, nil
+}
+
+// sourcePage returns the page with the source file name, or its
+// translation to the language of the page from (or of the site). A name
+// that doesn't start with a slash is relative to the source file of the
+// page from.
+func (s *Site) sourcePage(from *Page, name string) (*Page, error) {
+	if !strings.HasPrefix(name, "/") && from != nil {
+		name = path.Join(path.Dir(from.path), name)
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	p, ok := s.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("link to nonexistent page %s", name)
+	}
+	if p.Generate != "" {
+		return nil, fmt.Errorf("link to page %s that generates multiple pages", name)
+	}
+
+	lang := s.lang
+	if from != nil && from.Lang != "" {
+		lang = from.Lang
+	}
+	if t := p.translation(lang); t != nil {
+		return t, nil
+	}
+	return p, nil
+}
+
+// uriPath returns the URL path of the page output file uri, relative to
+// the site root: "docs/" for "docs/index.html".
+func uriPath(uri string) string {
+	uri = strings.TrimPrefix(uri, "/")
+	if uri == "index.html" {
+		return ""
+	}
+	if strings.HasSuffix(uri, "/index.html") {
+		return strings.TrimSuffix(uri, "index.html")
+	}
+	return uri
+}
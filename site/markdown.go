@@ -0,0 +1,745 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultMarkdownEngine is a name of the Markdown engine that is used
+// when none is configured.
+const DefaultMarkdownEngine = "blackfriday"
+
+// MarkdownConfig configures Markdown rendering.
+type MarkdownConfig struct {
+	// Engine is a name of the Markdown engine: "blackfriday" (default)
+	// or "goldmark", that follows the CommonMark specification.
+	Engine string `yaml:"engine"`
+
+	// Extensions enable or disable Markdown extensions by name, e.g.
+	// "footnotes: true".
+	Extensions map[string]bool `yaml:"extensions"`
+
+	// Renderer enables or disables HTML renderer flags by name, e.g.
+	// "smartypants: false".
+	Renderer map[string]bool `yaml:"renderer"`
+
+	// Highlight configures syntax highlighting of fenced code blocks.
+	Highlight HighlightConfig `yaml:"highlight"`
+
+	// Headings configures IDs and anchors of headings.
+	Headings HeadingsConfig `yaml:"headings"`
+
+	// TOC configures the table of contents of pages.
+	TOC TOCConfig `yaml:"toc"`
+}
+
+// Markdown renders Markdown to HTML.
+type Markdown interface {
+	// Render renders source. Nodes that have render hooks are rendered
+	// with them and links to page source files are resolved, unless
+	// ctx is nil.
+	Render(source []byte, ctx *renderContext) (*Document, error)
+}
+
+// Document is a rendered Markdown document.
+type Document struct {
+	HTML     []byte
+	Headings []*Heading // all headings in order of appearance
+}
+
+// markdownEngines contains constructors of available Markdown engines.
+var markdownEngines = map[string]func(cfg *MarkdownConfig) (Markdown, error){
+	"blackfriday": newBlackfriday,
+	"goldmark":    newGoldmark,
+}
+
+// newMarkdown returns a Markdown engine configured by cfg.
+func newMarkdown(cfg *MarkdownConfig) (Markdown, error) {
+	engine := cfg.Engine
+	if engine == "" {
+		engine = DefaultMarkdownEngine
+	}
+
+	newEngine, ok := markdownEngines[engine]
+	if !ok {
+		var names []string
+		for name := range markdownEngines {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("markdown: unknown engine %q (available: %s)", engine, strings.Join(names, ", "))
+	}
+
+	return newEngine(cfg)
+}
+
+// toggle returns names of enabled options: defaults overridden by
+// values from set. It fails on names that are not in known.
+func toggle(kind string, known []string, defaults []string, set map[string]bool) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for _, name := range defaults {
+		enabled[name] = true
+	}
+
+	valid := make(map[string]bool)
+	for _, name := range known {
+		valid[name] = true
+	}
+
+	for name, on := range set {
+		if !valid[name] {
+			sort.Strings(known)
+			return nil, fmt.Errorf("markdown: unknown %s %q (available: %s)", kind, name, strings.Join(known, ", "))
+		}
+		enabled[name] = on
+	}
+
+	return enabled, nil
+}
+
+// blackfridayMarkdown renders Markdown with Blackfriday.
+type blackfridayMarkdown struct {
+	extensions blackfriday.Extensions
+	flags      blackfriday.HTMLFlags
+	hl         *highlighter
+	headings   HeadingsConfig
+	autoIDs    bool
+}
+
+var (
+	blackfridayExtensions = map[string]blackfriday.Extensions{
+		"no_intra_emphasis":          blackfriday.NoIntraEmphasis,
+		"tables":                     blackfriday.Tables,
+		"fenced_code":                blackfriday.FencedCode,
+		"autolink":                   blackfriday.Autolink,
+		"strikethrough":              blackfriday.Strikethrough,
+		"lax_html_blocks":            blackfriday.LaxHTMLBlocks,
+		"space_headings":             blackfriday.SpaceHeadings,
+		"hard_line_breaks":           blackfriday.HardLineBreak,
+		"tab_size_eight":             blackfriday.TabSizeEight,
+		"footnotes":                  blackfriday.Footnotes,
+		"no_empty_line_before_block": blackfriday.NoEmptyLineBeforeBlock,
+		"heading_ids":                blackfriday.HeadingIDs,
+		"title_block":                blackfriday.Titleblock,
+		"auto_heading_ids":           0, // heading IDs are generated by the renderer
+		"backslash_line_breaks":      blackfriday.BackslashLineBreak,
+		"definition_lists":           blackfriday.DefinitionLists,
+	}
+	blackfridayFlags = map[string]blackfriday.HTMLFlags{
+		"skip_html":                 blackfriday.SkipHTML,
+		"skip_images":               blackfriday.SkipImages,
+		"skip_links":                blackfriday.SkipLinks,
+		"safelink":                  blackfriday.Safelink,
+		"nofollow_links":            blackfriday.NofollowLinks,
+		"noreferrer_links":          blackfriday.NoreferrerLinks,
+		"noopener_links":            blackfriday.NoopenerLinks,
+		"href_target_blank":         blackfriday.HrefTargetBlank,
+		"xhtml":                     blackfriday.UseXHTML,
+		"footnote_return_links":     blackfriday.FootnoteReturnLinks,
+		"smartypants":               blackfriday.Smartypants,
+		"smartypants_fractions":     blackfriday.SmartypantsFractions,
+		"smartypants_dashes":        blackfriday.SmartypantsDashes,
+		"smartypants_latex_dashes":  blackfriday.SmartypantsLatexDashes,
+		"smartypants_angled_quotes": blackfriday.SmartypantsAngledQuotes,
+		"smartypants_quotes_nbsp":   blackfriday.SmartypantsQuotesNBSP,
+	}
+)
+
+func newBlackfriday(cfg *MarkdownConfig) (Markdown, error) {
+	var (
+		md                       = &blackfridayMarkdown{}
+		knownExt, defaultExt     []string
+		knownFlags, defaultFlags []string
+		err                      error
+	)
+
+	md.hl, err = newHighlighter(cfg.Highlight)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := newHeadingIDs(cfg.Headings.Slug, false); err != nil {
+		return nil, err
+	}
+	md.headings = cfg.Headings
+
+	for name, ext := range blackfridayExtensions {
+		knownExt = append(knownExt, name)
+		if blackfriday.CommonExtensions&ext != 0 {
+			defaultExt = append(defaultExt, name)
+		}
+	}
+	defaultExt = append(defaultExt, "auto_heading_ids")
+	for name, flag := range blackfridayFlags {
+		knownFlags = append(knownFlags, name)
+		if blackfriday.CommonHTMLFlags&flag != 0 {
+			defaultFlags = append(defaultFlags, name)
+		}
+	}
+
+	exts, err := toggle("extension", knownExt, defaultExt, cfg.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	for name, on := range exts {
+		if on {
+			md.extensions |= blackfridayExtensions[name]
+		}
+	}
+	md.autoIDs = exts["auto_heading_ids"]
+
+	flags, err := toggle("renderer flag", knownFlags, defaultFlags, cfg.Renderer)
+	if err != nil {
+		return nil, err
+	}
+	for name, on := range flags {
+		if on {
+			md.flags |= blackfridayFlags[name]
+		}
+	}
+
+	return md, nil
+}
+
+func (md *blackfridayMarkdown) Render(source []byte, ctx *renderContext) (*Document, error) {
+	ids, err := newHeadingIDs(md.headings.Slug, md.autoIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The renderer keeps state, such as used heading IDs, so it's
+	// created for every document.
+	r := &blackfridayRenderer{
+		HTMLRenderer: blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+			Flags: md.flags,
+		}),
+		hl:          md.hl,
+		ctx:         ctx,
+		headingsCfg: &md.headings,
+		ids:         ids,
+	}
+	out := blackfriday.Run(source, blackfriday.WithExtensions(md.extensions), blackfriday.WithRenderer(r))
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &Document{HTML: out, Headings: r.headings}, nil
+}
+
+// blackfridayRenderer extends the Blackfriday HTML renderer.
+type blackfridayRenderer struct {
+	*blackfriday.HTMLRenderer
+	hl          *highlighter
+	ctx         *renderContext
+	headingsCfg *HeadingsConfig
+	ids         *headingIDs
+	headings    []*Heading
+	err         error // first error that happened during rendering
+}
+
+func (r *blackfridayRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	switch node.Type {
+	case blackfriday.Heading:
+		if node.IsTitleblock {
+			break
+		}
+		if !entering {
+			io.WriteString(w, r.headingsCfg.anchor(node.HeadingID))
+			break
+		}
+
+		title := blackfridayText(node)
+		// Headings without IDs are left out of the table of contents.
+		node.HeadingID = r.ids.id(title, node.HeadingID)
+		if node.HeadingID != "" {
+			r.headings = append(r.headings, &Heading{Level: node.Level, ID: node.HeadingID, Title: title})
+		}
+
+		if name, ok := r.ctx.lookupHook("heading"); ok {
+			return r.hook(w, name, &RenderHeading{
+				Level:     node.Level,
+				ID:        node.HeadingID,
+				Text:      r.renderChildren(node),
+				PlainText: title,
+				Page:      r.ctx.page,
+			})
+		}
+
+	case blackfriday.Link:
+		// Footnote references are links too.
+		if !entering || node.NoteID != 0 {
+			break
+		}
+		dest, err := r.ctx.resolveLink(string(node.Destination))
+		if err != nil {
+			r.err = err
+			return blackfriday.Terminate
+		}
+		node.Destination = []byte(dest)
+
+		if name, ok := r.ctx.lookupHook("link"); ok {
+			return r.hook(w, name, &RenderLink{
+				Destination: string(node.Destination),
+				Title:       string(node.Title),
+				Text:        r.renderChildren(node),
+				PlainText:   blackfridayText(node),
+				Page:        r.ctx.page,
+			})
+		}
+
+	case blackfriday.Image:
+		if !entering {
+			break
+		}
+		if name, ok := r.ctx.lookupHook("image"); ok {
+			alt := blackfridayText(node)
+			return r.hook(w, name, &RenderLink{
+				Destination: string(node.Destination),
+				Title:       string(node.Title),
+				Text:        template.HTML(template.HTMLEscapeString(alt)),
+				PlainText:   alt,
+				Page:        r.ctx.page,
+			})
+		}
+
+	case blackfriday.CodeBlock:
+		if r.ctx.hasHooks() {
+			lang, attrs, err := parseCodeInfo(string(node.Info))
+			if err != nil {
+				r.err = err
+				return blackfriday.Terminate
+			}
+			names := []string{"codeblock"}
+			if lang != "" {
+				names = append([]string{"codeblock-" + lang}, names...)
+			}
+			if name, ok := r.ctx.lookupHook(names...); ok {
+				return r.hook(w, name, &RenderCodeBlock{
+					Type:       lang,
+					Attributes: attrs,
+					Inner:      string(node.Literal),
+					Page:       r.ctx.page,
+				})
+			}
+		}
+
+		if r.hl == nil {
+			break
+		}
+		ok, err := r.hl.highlight(w, string(node.Literal), string(node.Info))
+		if err != nil {
+			r.err = err
+			return blackfriday.Terminate
+		}
+		if ok {
+			return blackfriday.GoToNext
+		}
+	}
+	return r.HTMLRenderer.RenderNode(w, node, entering)
+}
+
+// hook renders a node with the render hook name instead of its default
+// rendering.
+func (r *blackfridayRenderer) hook(w io.Writer, name string, data interface{}) blackfriday.WalkStatus {
+	if r.err != nil {
+		return blackfriday.Terminate
+	}
+	if err := r.ctx.executeHook(w, name, data); err != nil {
+		r.err = err
+		return blackfriday.Terminate
+	}
+	return blackfriday.SkipChildren
+}
+
+// renderChildren returns HTML of the node children.
+func (r *blackfridayRenderer) renderChildren(node *blackfriday.Node) template.HTML {
+	var buf bytes.Buffer
+	for c := node.FirstChild; c != nil; c = c.Next {
+		c.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+			return r.RenderNode(&buf, n, entering)
+		})
+	}
+	return template.HTML(buf.String())
+}
+
+// blackfridayText returns plain text of the node children.
+func blackfridayText(node *blackfriday.Node) string {
+	var b strings.Builder
+	node.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (n.Type == blackfriday.Text || n.Type == blackfriday.Code) {
+			b.Write(n.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return b.String()
+}
+
+// goldmarkMarkdown renders Markdown with goldmark.
+type goldmarkMarkdown struct {
+	extenders    []goldmark.Extender
+	parserOpts   []parser.Option
+	rendererOpts []renderer.Option
+	hl           *highlighter
+	headings     HeadingsConfig
+	autoIDs      bool
+}
+
+var (
+	goldmarkExtensions = map[string]goldmark.Extender{
+		"tables":           extension.Table,
+		"strikethrough":    extension.Strikethrough,
+		"autolink":         extension.Linkify,
+		"task_lists":       extension.TaskList,
+		"footnotes":        extension.Footnote,
+		"definition_lists": extension.DefinitionList,
+	}
+	goldmarkParserOptions = map[string]parser.Option{
+		"auto_heading_ids": nil, // heading IDs are generated by the renderer
+		"heading_ids":      parser.WithHeadingAttribute(),
+	}
+	// Hard line breaks are an extension in Blackfriday, so they're
+	// configured as an extension here too.
+	goldmarkRendererOptions = map[string]renderer.Option{
+		"hard_line_breaks": html.WithHardWraps(),
+	}
+)
+
+func newGoldmark(cfg *MarkdownConfig) (Markdown, error) {
+	var knownExt []string
+	for name := range goldmarkExtensions {
+		knownExt = append(knownExt, name)
+	}
+	for name := range goldmarkParserOptions {
+		knownExt = append(knownExt, name)
+	}
+	for name := range goldmarkRendererOptions {
+		knownExt = append(knownExt, name)
+	}
+	exts, err := toggle("extension", knownExt, []string{
+		"tables", "strikethrough", "autolink", "task_lists", "definition_lists", "heading_ids", "auto_heading_ids",
+	}, cfg.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := toggle("renderer flag", []string{"skip_html", "smartypants", "xhtml"}, []string{"smartypants", "xhtml"}, cfg.Renderer)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		extenders    []goldmark.Extender
+		parserOpts   []parser.Option
+		rendererOpts []renderer.Option
+	)
+	for name, on := range exts {
+		if !on {
+			continue
+		}
+		if ext, ok := goldmarkExtensions[name]; ok {
+			extenders = append(extenders, ext)
+		}
+		if opt, ok := goldmarkParserOptions[name]; ok && opt != nil {
+			parserOpts = append(parserOpts, opt)
+		}
+		if opt, ok := goldmarkRendererOptions[name]; ok {
+			rendererOpts = append(rendererOpts, opt)
+		}
+	}
+	if flags["smartypants"] {
+		extenders = append(extenders, extension.Typographer)
+	}
+	if flags["xhtml"] {
+		rendererOpts = append(rendererOpts, html.WithXHTML())
+	}
+	// Raw HTML is passed through, as in Blackfriday, unless skipped.
+	if !flags["skip_html"] {
+		rendererOpts = append(rendererOpts, html.WithUnsafe())
+	}
+
+	hl, err := newHighlighter(cfg.Highlight)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := newHeadingIDs(cfg.Headings.Slug, false); err != nil {
+		return nil, err
+	}
+
+	return &goldmarkMarkdown{
+		extenders:    extenders,
+		parserOpts:   parserOpts,
+		rendererOpts: rendererOpts,
+		hl:           hl,
+		headings:     cfg.Headings,
+		autoIDs:      exts["auto_heading_ids"],
+	}, nil
+}
+
+func (md *goldmarkMarkdown) Render(source []byte, ctx *renderContext) (*Document, error) {
+	ids, err := newHeadingIDs(md.headings.Slug, md.autoIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The renderer keeps state, such as used heading IDs, so it's
+	// created for every document.
+	r := &goldmarkRenderer{
+		hl:          md.hl,
+		ctx:         ctx,
+		headingsCfg: &md.headings,
+		ids:         ids,
+	}
+	links := &goldmarkLinks{ctx: ctx}
+	gm := goldmark.New(
+		goldmark.WithExtensions(md.extenders...),
+		goldmark.WithParserOptions(md.parserOpts...),
+		goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(links, 100))),
+		goldmark.WithRendererOptions(md.rendererOpts...),
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(r, 200))),
+	)
+	// Render hooks get the rendered text of nodes.
+	r.renderer = gm.Renderer()
+
+	var buf bytes.Buffer
+	if err := gm.Convert(source, &buf); err != nil {
+		return nil, err
+	}
+	if links.err != nil {
+		return nil, links.err
+	}
+	return &Document{HTML: buf.Bytes(), Headings: r.headings}, nil
+}
+
+// goldmarkLinks resolves links to page source files.
+type goldmarkLinks struct {
+	ctx *renderContext
+	err error // first error that happened during resolving
+}
+
+func (t *goldmarkLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := node.(*ast.Link)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		dest, err := t.ctx.resolveLink(string(link.Destination))
+		if err != nil {
+			t.err = err
+			return ast.WalkStop, nil
+		}
+		link.Destination = []byte(dest)
+		return ast.WalkContinue, nil
+	})
+}
+
+// goldmarkRenderer overrides rendering of some goldmark nodes.
+type goldmarkRenderer struct {
+	hl          *highlighter
+	ctx         *renderContext
+	renderer    renderer.Renderer // renders children of hooked nodes
+	headingsCfg *HeadingsConfig
+	ids         *headingIDs
+	headings    []*Heading
+}
+
+func (r *goldmarkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindHeading, r.renderHeading)
+	// Links and images without render hooks are left to the default
+	// renderer.
+	if _, ok := r.ctx.lookupHook("link"); ok {
+		reg.Register(ast.KindLink, r.renderLink)
+	}
+	if _, ok := r.ctx.lookupHook("image"); ok {
+		reg.Register(ast.KindImage, r.renderImage)
+	}
+	if r.hl != nil || r.ctx.hasHooks() {
+		reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+	}
+}
+
+func (r *goldmarkRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.Heading)
+
+	var id string
+	if v, ok := n.AttributeString("id"); ok {
+		if b, ok := v.([]byte); ok {
+			id = string(b)
+		}
+	}
+
+	hook, hooked := r.ctx.lookupHook("heading")
+	if !entering {
+		if !hooked {
+			w.WriteString(r.headingsCfg.anchor(id))
+			fmt.Fprintf(w, "</h%d>\n", n.Level)
+		}
+		return ast.WalkContinue, nil
+	}
+
+	// Headings without IDs are left out of the table of contents.
+	title := string(n.Text(source))
+	if id = r.ids.id(title, id); id != "" {
+		n.SetAttributeString("id", []byte(id))
+		r.headings = append(r.headings, &Heading{Level: n.Level, ID: id, Title: title})
+	}
+
+	if hooked {
+		text, err := r.renderChildren(source, n)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		return r.hook(w, hook, &RenderHeading{
+			Level:     n.Level,
+			ID:        id,
+			Text:      text,
+			PlainText: title,
+			Page:      r.ctx.page,
+		})
+	}
+
+	fmt.Fprintf(w, "<h%d", n.Level)
+	html.RenderAttributes(w, n, html.HeadingAttributeFilter)
+	w.WriteByte('>')
+	return ast.WalkContinue, nil
+}
+
+func (r *goldmarkRenderer) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Link)
+
+	text, err := r.renderChildren(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	hook, _ := r.ctx.lookupHook("link")
+	return r.hook(w, hook, &RenderLink{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        text,
+		PlainText:   string(n.Text(source)),
+		Page:        r.ctx.page,
+	})
+}
+
+func (r *goldmarkRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+
+	alt := string(n.Text(source))
+	hook, _ := r.ctx.lookupHook("image")
+	return r.hook(w, hook, &RenderLink{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        template.HTML(template.HTMLEscapeString(alt)),
+		PlainText:   alt,
+		Page:        r.ctx.page,
+	})
+}
+
+// hook renders a node with the render hook name instead of its default
+// rendering.
+func (r *goldmarkRenderer) hook(w util.BufWriter, name string, data interface{}) (ast.WalkStatus, error) {
+	if err := r.ctx.executeHook(w, name, data); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// renderChildren returns HTML of the node children.
+func (r *goldmarkRenderer) renderChildren(source []byte, node ast.Node) (template.HTML, error) {
+	var buf bytes.Buffer
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.renderer.Render(&buf, source, c); err != nil {
+			return "", err
+		}
+	}
+	return template.HTML(buf.String()), nil
+}
+
+func (r *goldmarkRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var info string
+	if n.Info != nil {
+		info = string(n.Info.Segment.Value(source))
+	}
+
+	var code bytes.Buffer
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		code.Write(line.Value(source))
+	}
+
+	if r.ctx.hasHooks() {
+		lang, attrs, err := parseCodeInfo(info)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		names := []string{"codeblock"}
+		if lang != "" {
+			names = append([]string{"codeblock-" + lang}, names...)
+		}
+		if name, ok := r.ctx.lookupHook(names...); ok {
+			return r.hook(w, name, &RenderCodeBlock{
+				Type:       lang,
+				Attributes: attrs,
+				Inner:      code.String(),
+				Page:       r.ctx.page,
+			})
+		}
+	}
+
+	if r.hl != nil {
+		ok, err := r.hl.highlight(w, code.String(), info)
+		if err != nil {
+			return ast.WalkStop, err
+		}
+		if ok {
+			return ast.WalkSkipChildren, nil
+		}
+	}
+	writeGoldmarkCode(w, source, n)
+	return ast.WalkSkipChildren, nil
+}
+
+// writeGoldmarkCode writes the fenced code block as the default goldmark
+// renderer does, with the language class.
+func writeGoldmarkCode(w util.BufWriter, source []byte, n *ast.FencedCodeBlock) {
+	w.WriteString("<pre><code")
+	if lang := n.Language(source); lang != nil {
+		w.WriteString(` class="language-`)
+		html.DefaultWriter.Write(w, lang)
+		w.WriteByte('"')
+	}
+	w.WriteByte('>')
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		html.DefaultWriter.RawWrite(w, line.Value(source))
+	}
+	w.WriteString("</code></pre>\n")
+}
@@ -0,0 +1,257 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	cases := map[string]struct {
+		cfg  MarkdownConfig
+		in   string
+		want string
+	}{
+		"blackfriday defaults": {
+			in:   "~~old~~ \"new\"",
+			want: "<p><del>old</del> &ldquo;new&rdquo;</p>\n",
+		},
+		"blackfriday without smartypants": {
+			cfg:  MarkdownConfig{Renderer: map[string]bool{"smartypants": false}},
+			in:   "\"new\"",
+			want: "<p>&quot;new&quot;</p>\n",
+		},
+		"blackfriday hard line breaks": {
+			cfg:  MarkdownConfig{Extensions: map[string]bool{"hard_line_breaks": true}},
+			in:   "a\nb",
+			want: "<p>a<br />\nb</p>\n",
+		},
+		"blackfriday without strikethrough": {
+			cfg:  MarkdownConfig{Extensions: map[string]bool{"strikethrough": false}},
+			in:   "~~old~~",
+			want: "<p>~~old~~</p>\n",
+		},
+		"blackfriday heading ids": {
+			in:   "# Hello World",
+			want: "<h1 id=\"hello-world\">Hello World</h1>\n",
+		},
+		"goldmark defaults": {
+			cfg:  MarkdownConfig{Engine: "goldmark"},
+			in:   "~~old~~ <b>raw</b>",
+			want: "<p><del>old</del> <b>raw</b></p>\n",
+		},
+		"goldmark footnotes": {
+			cfg:  MarkdownConfig{Engine: "goldmark", Extensions: map[string]bool{"footnotes": true}},
+			in:   "Text[^1].\n\n[^1]: Note.",
+			want: "footnote-ref",
+		},
+		"goldmark follows commonmark": {
+			// Blackfriday doesn't allow lists to interrupt paragraphs.
+			cfg:  MarkdownConfig{Engine: "goldmark"},
+			in:   "Paragraph\n- item",
+			want: "<p>Paragraph</p>\n<ul>\n<li>item</li>\n</ul>\n",
+		},
+	}
+
+	for name, tc := range cases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			md, err := newMarkdown(&tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc, err := md.Render([]byte(tc.in), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(doc.HTML); got != tc.want && !strings.Contains(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarkdownUnknownOptions(t *testing.T) {
+	for _, cfg := range []MarkdownConfig{
+		{Engine: "unknown"},
+		{Extensions: map[string]bool{"unknown": true}},
+		{Engine: "goldmark", Renderer: map[string]bool{"unknown": true}},
+	} {
+		if _, err := newMarkdown(&cfg); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	// Attributes unknown to the highlighter, like title, are ignored.
+	const src = "```go {hl_lines=[2] linenos=true title=\"main.go\"}\npackage main\n\nfunc main() {}\n```\n\n```\nplain <text>\n```\n\n```mermaid\ngraph <LR>\n```\n"
+
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			md, err := newMarkdown(&MarkdownConfig{
+				Engine:    engine,
+				Highlight: HighlightConfig{Enabled: true, Classes: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc, err := md.Render([]byte(src), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(doc.HTML)
+
+			for _, want := range []string{
+				`class="chroma"`,
+				`<span class="kn">package</span>`,
+				`<span class="line hl"><span class="ln">2</span>`,
+				`plain &lt;text&gt;`,
+				"<pre><code class=\"language-mermaid\">graph &lt;LR&gt;\n</code></pre>",
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("%q doesn't contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHighlightStable(t *testing.T) {
+	h, err := newHighlighter(HighlightConfig{Enabled: true, Classes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first string
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		if _, err := h.highlight(&buf, "a := 1\nb := 2\n", `go {linenos=table hl_lines=[2] linenostart=5 title="x.go"}`); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = buf.String()
+		} else if buf.String() != first {
+			t.Fatalf("got %q, then %q", first, buf.String())
+		}
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	const src = "# Intro\n\n## Setup `gen`\n\n## Setup gen\n\n### Deep {#custom}\n\n# Привет, мир!\n"
+
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			md, err := newMarkdown(&MarkdownConfig{
+				Engine:   engine,
+				Headings: HeadingsConfig{Anchors: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc, err := md.Render([]byte(src), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(doc.HTML)
+
+			for _, want := range []string{
+				`<h1 id="intro">Intro <a class="anchor" href="#intro" aria-hidden="true">#</a></h1>`,
+				`<h2 id="setup-gen">`,
+				`<h2 id="setup-gen-1">`,
+				`<h3 id="custom">`,
+				`<h1 id="привет-мир">`,
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("%q doesn't contain %q", got, want)
+				}
+			}
+
+			var ids []string
+			for _, h := range doc.Headings {
+				ids = append(ids, h.ID)
+			}
+			if want := []string{"intro", "setup-gen", "setup-gen-1", "custom", "привет-мир"}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("got heading IDs %v, want %v", ids, want)
+			}
+
+			toc := tableOfContents(headingTree(doc.Headings, TOCConfig{MaxDepth: 2}))
+			if want := template.HTML(`<nav class="toc"><ul><li><a href="#intro">Intro</a><ul><li><a href="#setup-gen">Setup gen</a></li><li><a href="#setup-gen-1">Setup gen</a></li></ul></li><li><a href="#привет-мир">Привет, мир!</a></li></ul></nav>`); toc != want {
+				t.Errorf("got table of contents %q, want %q", toc, want)
+			}
+		})
+	}
+}
+
+func TestHeadingsWithoutAutoIDs(t *testing.T) {
+	const src = "# Intro\n\n## Deep {#custom}\n"
+
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		engine := engine
+		t.Run(engine, func(t *testing.T) {
+			md, err := newMarkdown(&MarkdownConfig{
+				Engine:     engine,
+				Extensions: map[string]bool{"auto_heading_ids": false},
+				Headings:   HeadingsConfig{Anchors: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			doc, err := md.Render([]byte(src), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(doc.HTML)
+
+			for _, want := range []string{
+				"<h1>Intro</h1>",
+				`<h2 id="custom">Deep <a class="anchor" href="#custom" aria-hidden="true">#</a></h2>`,
+			} {
+				if !strings.Contains(got, want) {
+					t.Errorf("%q doesn't contain %q", got, want)
+				}
+			}
+			if len(doc.Headings) != 1 || doc.Headings[0].ID != "custom" {
+				t.Errorf("got headings %+v, want only the one with an explicit ID", doc.Headings)
+			}
+		})
+	}
+}
+
+func TestParseCodeInfo(t *testing.T) {
+	lang, attrs, err := parseCodeInfo(`go {hl_lines=[3,"5-7"], linenos=table linenostart="10"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang != "go" {
+		t.Errorf("got language %q, want go", lang)
+	}
+	if attrs["linenos"] != "table" || attrs["linenostart"] != "10" {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+
+	ranges, err := parseLineRanges(attrs["hl_lines"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]int{{3, 3}, {5, 7}}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("got ranges %v, want %v", ranges, want)
+	}
+
+	if _, _, err := parseCodeInfo("go {hl_lines=[3"); err == nil {
+		t.Error("expected an error for unterminated attributes")
+	}
+}
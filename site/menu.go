@@ -0,0 +1,229 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MenuEntry is an entry of a site menu.
+type MenuEntry struct {
+	Name string `yaml:"name"` // defaults to the page title
+	URL  string `yaml:"url"`  // defaults to the page URL
+
+	// Source is a source file of the page that the entry links to,
+	// relative to the pages directory. It's set only in gen.yaml.
+	Source string `yaml:"page"`
+
+	// Identifier identifies the entry for children. Default is the
+	// entry name.
+	Identifier string `yaml:"identifier"`
+
+	// Parent is an identifier of the parent entry.
+	Parent string `yaml:"parent"`
+
+	// Weight orders entries: lighter entries go first, entries without
+	// weight go last.
+	Weight int `yaml:"weight"`
+
+	Page     *Page `yaml:"-"` // page that the entry links to, if any
+	Children Menu  `yaml:"-"`
+}
+
+// Menu is a list of menu entries.
+type Menu []*MenuEntry
+
+// Menus are site menus by name.
+type Menus map[string]Menu
+
+// HasChildren reports whether the entry has children.
+func (e *MenuEntry) HasChildren() bool { return len(e.Children) > 0 }
+
+// IsActive reports whether the entry or one of its descendants links to
+// the page p.
+func (e *MenuEntry) IsActive(p *Page) bool {
+	if p == nil {
+		return false
+	}
+	if e.Page == p {
+		return true
+	}
+	if e.Page == nil && e.URL != "" && p.s != nil {
+		if u, err := p.s.relURL(uriPath(p.URI)); err == nil && u == e.URL {
+			return true
+		}
+	}
+	for _, c := range e.Children {
+		if c.IsActive(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageMenus are menus that a page is added to. In frontmatter it's a
+// menu name, a list of names or a map of names to menu entries:
+//
+//	menu: main
+//	menu: [main, footer]
+//	menu:
+//	  main:
+//	    parent: docs
+//	    weight: 2
+type pageMenus map[string]*MenuEntry
+
+func (m *pageMenus) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*m = make(pageMenus)
+
+	var name string
+	if err := unmarshal(&name); err == nil {
+		(*m)[name] = &MenuEntry{}
+		return nil
+	}
+
+	var names []string
+	if err := unmarshal(&names); err == nil {
+		for _, name := range names {
+			(*m)[name] = &MenuEntry{}
+		}
+		return nil
+	}
+
+	entries := make(map[string]*MenuEntry)
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+	for name, e := range entries {
+		if e == nil {
+			e = &MenuEntry{}
+		}
+		(*m)[name] = e
+	}
+	return nil
+}
+
+// Menus returns site menus.
+func (s *Site) Menus() Menus { return s.menus }
+
+// buildMenus builds menus from entries in the site configuration and
+// frontmatter of pages.
+func (s *Site) buildMenus() error {
+	entries := make(map[string][]*MenuEntry)
+
+	for name, menu := range s.cfg.Menus {
+		for _, ce := range menu {
+			e := *ce
+			if e.Source != "" {
+				p, err := s.sourcePage(nil, e.Source)
+				if err != nil {
+					return fmt.Errorf("menu %s: %w", name, err)
+				}
+				e.Page = p
+			}
+			entries[name] = append(entries[name], &e)
+		}
+	}
+
+	for _, p := range s.pages {
+		for _, name := range sortedMenuNames(p.Menu) {
+			e := *p.Menu[name]
+			e.Page = p
+			if e.Weight == 0 {
+				e.Weight = p.Weight
+			}
+			entries[name] = append(entries[name], &e)
+		}
+	}
+
+	s.menus = make(Menus)
+	for name, es := range entries {
+		ids := make(map[string]*MenuEntry)
+		for _, e := range es {
+			if e.Page != nil {
+				if e.Name == "" {
+					e.Name = e.Page.Title
+				}
+				if e.URL == "" {
+					u, err := s.relURL(uriPath(e.Page.URI))
+					if err != nil {
+						return err
+					}
+					e.URL = u
+				}
+			}
+			if e.Name == "" {
+				return fmt.Errorf("menu %s: entry %s has no name", name, e.URL)
+			}
+			if e.Identifier == "" {
+				e.Identifier = e.Name
+			}
+			if _, ok := ids[e.Identifier]; ok {
+				return fmt.Errorf("menu %s: duplicate entry %s", name, e.Identifier)
+			}
+			ids[e.Identifier] = e
+		}
+
+		var menu Menu
+		for _, e := range es {
+			if e.Parent == "" {
+				menu = append(menu, e)
+				continue
+			}
+			parent, ok := ids[e.Parent]
+			if !ok || parent == e {
+				return fmt.Errorf("menu %s: entry %s has unknown parent %s", name, e.Identifier, e.Parent)
+			}
+			parent.Children = append(parent.Children, e)
+		}
+		if n := menu.count(); n != len(es) {
+			return fmt.Errorf("menu %s: parents of %d entries form a cycle", name, len(es)-n)
+		}
+		menu.sort()
+		s.menus[name] = menu
+	}
+
+	return nil
+}
+
+// sort sorts the menu and its children by weight and name.
+func (m Menu) sort() {
+	sort.SliceStable(m, func(i, j int) bool {
+		if m[i].Weight != m[j].Weight {
+			return lessWeight(m[i].Weight, m[j].Weight)
+		}
+		return m[i].Name < m[j].Name
+	})
+	for _, e := range m {
+		e.Children.sort()
+	}
+}
+
+// count returns a number of entries in the menu, including children.
+func (m Menu) count() int {
+	n := len(m)
+	for _, e := range m {
+		n += e.Children.count()
+	}
+	return n
+}
+
+// lessWeight reports whether weight a goes before b. Zero weight means
+// no weight and goes last.
+func lessWeight(a, b int) bool {
+	if a == 0 || b == 0 {
+		return b == 0 && a != 0
+	}
+	return a < b
+}
+
+func sortedMenuNames(m pageMenus) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
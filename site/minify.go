@@ -0,0 +1,207 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+
+	"github.com/tdewolff/minify"
+	"github.com/tdewolff/minify/css"
+	"github.com/tdewolff/minify/html"
+	"github.com/tdewolff/minify/js"
+	"github.com/tdewolff/minify/json"
+	"github.com/tdewolff/minify/svg"
+	"github.com/tdewolff/minify/xml"
+)
+
+// MinifyConfig configures minifiers, that are used when the site is
+// built with minification.
+//
+// Comments are always removed, except IE conditional comments in HTML:
+// the vendored minify v2.3.6 has no option to keep them. Files whose
+// comments matter can be excluded.
+type MinifyConfig struct {
+	HTML HTMLMinifyConfig `yaml:"html"`
+	CSS  CSSMinifyConfig  `yaml:"css"`
+	SVG  SVGMinifyConfig  `yaml:"svg"`
+	XML  XMLMinifyConfig  `yaml:"xml"`
+
+	// Exclude are glob patterns of output files that are not minified.
+	// A pattern without a slash matches file names, otherwise paths
+	// relative to the output directory.
+	Exclude []string `yaml:"exclude"`
+}
+
+// HTMLMinifyConfig configures minification of HTML.
+type HTMLMinifyConfig struct {
+	KeepConditionalComments bool  `yaml:"keep_conditional_comments"` // IE conditional comments
+	KeepDefaultAttrVals     bool  `yaml:"keep_default_attr_vals"`    // e.g. type="text"
+	KeepDocumentTags        *bool `yaml:"keep_document_tags"`        // html, head and body; true by default
+	KeepEndTags             *bool `yaml:"keep_end_tags"`             // true by default
+	KeepWhitespace          bool  `yaml:"keep_whitespace"`
+}
+
+// CSSMinifyConfig configures minification of CSS.
+type CSSMinifyConfig struct {
+	// Decimals is a number of decimals to keep in numbers. Default is
+	// -1, that keeps all of them.
+	Decimals *int `yaml:"decimals"`
+
+	// KeepCSS2 disables minifications that need CSS3.
+	KeepCSS2 bool `yaml:"keep_css2"`
+}
+
+// SVGMinifyConfig configures minification of SVG.
+type SVGMinifyConfig struct {
+	// Decimals is a number of decimals to keep in numbers. Default is
+	// -1, that keeps all of them.
+	Decimals *int `yaml:"decimals"`
+}
+
+// XMLMinifyConfig configures minification of XML, such as feeds and
+// sitemaps.
+type XMLMinifyConfig struct {
+	KeepWhitespace bool `yaml:"keep_whitespace"`
+}
+
+// validate reports whether the exclude patterns are malformed.
+func (c *MinifyConfig) validate() error {
+	for _, pattern := range c.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("minify: %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// excluded reports whether the output file rel is not minified.
+func (c *MinifyConfig) excluded(rel string) bool {
+	return matchPath(c.Exclude, strings.TrimPrefix(rel, "/"))
+}
+
+// newMinifier returns minifiers of HTML, CSS, JavaScript, JSON, SVG and
+// XML configured by cfg.
+func newMinifier(cfg *MinifyConfig) *minify.M {
+
+	m := minify.New()
+	m.Add("text/html", &html.Minifier{
+		KeepConditionalComments: cfg.HTML.KeepConditionalComments,
+		KeepDefaultAttrVals:     cfg.HTML.KeepDefaultAttrVals,
+		KeepDocumentTags:        boolOr(cfg.HTML.KeepDocumentTags, true),
+		KeepEndTags:             boolOr(cfg.HTML.KeepEndTags, true),
+		KeepWhitespace:          cfg.HTML.KeepWhitespace,
+	})
+	m.Add("text/css", &css.Minifier{
+		Decimals: intOr(cfg.CSS.Decimals, -1),
+		KeepCSS2: cfg.CSS.KeepCSS2,
+	})
+	m.AddFuncRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), js.Minify)
+	m.AddFuncRegexp(regexp.MustCompile("[/+]json$"), json.Minify)
+	m.Add("image/svg+xml", &svg.Minifier{
+		Decimals: intOr(cfg.SVG.Decimals, -1),
+	})
+	m.AddRegexp(regexp.MustCompile("[/+]xml$"), &xml.Minifier{
+		KeepWhitespace: cfg.XML.KeepWhitespace,
+	})
+	return m
+}
+
+// minifyStaticFiles copies files from the static directory src to the
+// output directory, minifying the ones that can be minified and are
+// not excluded.
+func (s *Site) minifyStaticFiles(src string) error {
+	files, err := fileutil.Files(src)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(s.dst, rel)
+
+		if err := fileutil.Mkdir(filepath.Dir(dst)); err != nil {
+			return err
+		}
+
+		if s.cfg.Minify.excluded(filepath.ToSlash(rel)) {
+			if err := fileutil.CopyFile(file, dst); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := minifyFile(s.m, mime.TypeByExtension(filepath.Ext(file)), file, dst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// minifyFile minifies the file src of media type typ to dst, or copies
+// it if there's no minifier for the type.
+func minifyFile(m *minify.M, typ, src, dst string) error {
+	from, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	to, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	err = m.Minify(typ, to, from)
+	if err == minify.ErrNotExist {
+		_, err = io.Copy(to, from)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return nil
+}
+
+// matchPath reports whether the slash-separated path rel matches one of
+// glob patterns. A pattern without a slash matches the file name.
+func matchPath(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+func intOr(n *int, def int) int {
+	if n == nil {
+		return def
+	}
+	return *n
+}
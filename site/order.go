@@ -0,0 +1,63 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import "sort"
+
+// sortPages sorts pages by weight (pages without weight go last), then
+// by date (newer pages go first), then by title.
+func sortPages(pages []*Page) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if a.Weight != b.Weight {
+			return lessWeight(a.Weight, b.Weight)
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		return a.Title < b.Title
+	})
+}
+
+// linkPages links sorted site pages to their neighbors.
+func (s *Site) linkPages() {
+	sections := make(map[string][]*Page)
+	for i, p := range s.pages {
+		p.prev, p.next = nil, nil
+		if i > 0 {
+			p.prev = s.pages[i-1]
+		}
+		if i < len(s.pages)-1 {
+			p.next = s.pages[i+1]
+		}
+		sections[p.Section()] = append(sections[p.Section()], p)
+	}
+
+	for _, pages := range sections {
+		for i, p := range pages {
+			p.prevInSection, p.nextInSection = nil, nil
+			if i > 0 {
+				p.prevInSection = pages[i-1]
+			}
+			if i < len(pages)-1 {
+				p.nextInSection = pages[i+1]
+			}
+		}
+	}
+}
+
+// Next returns the page that follows the page in .Site.Pages, or nil.
+func (p *Page) Next() *Page { return p.next }
+
+// Prev returns the page that precedes the page in .Site.Pages, or nil.
+func (p *Page) Prev() *Page { return p.prev }
+
+// NextInSection returns the page that follows the page in its section,
+// or nil.
+func (p *Page) NextInSection() *Page { return p.nextInSection }
+
+// PrevInSection returns the page that precedes the page in its section,
+// or nil.
+func (p *Page) PrevInSection() *Page { return p.prevInSection }
@@ -0,0 +1,263 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// AssetsDir is a directory with stylesheets and scripts that are
+// bundled by css and js template functions. It can be provided by both
+// the site and its themes.
+const AssetsDir = "assets"
+
+// PipelineConfig configures bundling of stylesheets and scripts.
+type PipelineConfig struct {
+	// Minify minifies bundles. They are also minified when the site is
+	// built with minification.
+	Minify bool `yaml:"minify"`
+
+	// SourceMaps writes a source map next to every bundle.
+	SourceMaps bool `yaml:"source_maps"`
+
+	// Target is a JavaScript version that scripts are compiled to:
+	// "es2015" to "es2020", or "esnext" (the default).
+	Target string `yaml:"target"`
+}
+
+var jsTargets = map[string]api.Target{
+	"":       api.ESNext,
+	"esnext": api.ESNext,
+	"es2015": api.ES2015,
+	"es2016": api.ES2016,
+	"es2017": api.ES2017,
+	"es2018": api.ES2018,
+	"es2019": api.ES2019,
+	"es2020": api.ES2020,
+}
+
+// validate reports whether the configuration has unknown target.
+func (c *PipelineConfig) validate() error {
+	if _, ok := jsTargets[c.Target]; !ok {
+		return fmt.Errorf("pipeline: unknown target %s", c.Target)
+	}
+	return nil
+}
+
+// css returns the stylesheet bundled from the file name in the assets
+// directory, with local @import rules replaced by imported files.
+func (s *Site) css(name string) (*Asset, error) {
+	return s.bundle(name, ".css", s.bundleCSS)
+}
+
+// js returns the script bundled from the ES module name in the assets
+// directory with its imports.
+func (s *Site) js(name string) (*Asset, error) {
+	return s.bundle(name, ".js", s.bundleJS)
+}
+
+// bundle builds the asset name once per build with build, writes it and
+// its source map to the output directory with the extension ext, and
+// fingerprints it if needed.
+func (s *Site) bundle(name, ext string, build func(dir, name, out string) (b, sourceMap []byte, err error)) (*Asset, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	out := strings.TrimSuffix(name, path.Ext(name)) + ext
+
+	if a, ok := s.bundles[name]; ok {
+		return a, nil
+	}
+
+	var dir string
+	for _, d := range s.lookupDirs(AssetsDir) {
+		if fileutil.Exists(filepath.Join(d, filepath.FromSlash(name))) {
+			dir = d
+			break
+		}
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("%s: no such file in %s", name, AssetsDir)
+	}
+
+	b, sourceMap, err := build(dir, name, out)
+	if err != nil {
+		return nil, err
+	}
+
+	rel := out
+	if s.cfg.Fingerprint.match(out) {
+		rel = fingerprintName(out, b)
+		s.fingerprinted[rel] = true
+		s.manifest[out] = rel
+	}
+
+	if sourceMap != nil {
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		comment := "//# sourceMappingURL=%s\n"
+		if ext == ".css" {
+			comment = "/*# sourceMappingURL=%s */\n"
+		}
+		b = append(b, fmt.Sprintf(comment, path.Base(rel)+".map")...)
+		if err := s.writeOutput(rel+".map", sourceMap); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.writeOutput(rel, b); err != nil {
+		return nil, err
+	}
+
+	a := &Asset{Path: rel, Integrity: integrity(b)}
+	if a.URL, err = s.relURL(rel); err != nil {
+		return nil, err
+	}
+	s.bundles[name] = a
+	return a, nil
+}
+
+// writeOutput writes b to the file name in the output directory.
+func (s *Site) writeOutput(name string, b []byte) error {
+	file := filepath.Join(s.dst, filepath.FromSlash(name))
+	if err := fileutil.Mkdir(filepath.Dir(file)); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// minifyBundle reports whether the bundle out is minified.
+func (s *Site) minifyBundle(out string) bool {
+	return (s.minify || s.cfg.Pipeline.Minify) && !s.cfg.Minify.excluded(out)
+}
+
+// bundleCSS bundles the stylesheet name from the assets directory dir
+// with esbuild and minifies it.
+func (s *Site) bundleCSS(dir, name, out string) (b, sourceMap []byte, err error) {
+	root, err := filepath.Abs(filepath.Dir(filepath.Join(dir, filepath.FromSlash(name))))
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := api.BuildOptions{
+		Plugins: []api.Plugin{cssPaths(root)},
+	}
+	if s.minifyBundle(out) {
+		opts.MinifyWhitespace = true
+		opts.MinifySyntax = true
+	}
+	return s.esbuild(opts, dir, name, out)
+}
+
+// cssPaths resolves local @import rules against the directory of the
+// importing stylesheet and keeps url() references, with relative ones
+// rewritten against the directory of the entry stylesheet root.
+func cssPaths(root string) api.Plugin {
+	return api.Plugin{
+		Name: "css-paths",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(api.OnResolveOptions{Filter: ".*"}, func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+				remote := strings.HasPrefix(args.Path, "//") || strings.Contains(args.Path, ":")
+				switch args.Kind {
+				case api.ResolveCSSImportRule:
+					if remote {
+						break
+					}
+					return api.OnResolveResult{Path: filepath.Join(args.ResolveDir, filepath.FromSlash(args.Path))}, nil
+				case api.ResolveCSSURLToken:
+					url := args.Path
+					if !remote && !strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "#") {
+						rel, err := filepath.Rel(root, filepath.Join(args.ResolveDir, filepath.FromSlash(url)))
+						if err != nil {
+							return api.OnResolveResult{}, err
+						}
+						url = filepath.ToSlash(rel)
+					}
+					return api.OnResolveResult{Path: url, External: true}, nil
+				}
+				return api.OnResolveResult{}, nil
+			})
+		},
+	}
+}
+
+// bundleJS bundles the script name from the assets directory dir with
+// esbuild and minifies it.
+func (s *Site) bundleJS(dir, name, out string) (b, sourceMap []byte, err error) {
+	opts := api.BuildOptions{
+		Target: jsTargets[s.cfg.Pipeline.Target],
+	}
+	// Minify can't keep source maps, so esbuild minifies scripts that
+	// have them.
+	minifyJS := s.minifyBundle(out)
+	if s.cfg.Pipeline.SourceMaps {
+		opts.MinifyWhitespace = minifyJS
+		opts.MinifyIdentifiers = minifyJS
+		opts.MinifySyntax = minifyJS
+		minifyJS = false
+	}
+
+	if b, sourceMap, err = s.esbuild(opts, dir, name, out); err != nil {
+		return nil, nil, err
+	}
+
+	if minifyJS {
+		if b, err = s.m.Bytes("text/javascript", b); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", filepath.Join(dir, filepath.FromSlash(name)), err)
+		}
+	}
+
+	return b, sourceMap, nil
+}
+
+// esbuild bundles the file name from the assets directory dir into the
+// output file out with esbuild, configured by opts. The source map is
+// returned if enabled.
+func (s *Site) esbuild(opts api.BuildOptions, dir, name, out string) (b, sourceMap []byte, err error) {
+	file, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Comments in unminified bundles name files relative to the
+	// working directory, and sources in source maps are relative to the
+	// output file, as if the assets directory was the output directory.
+	// Nothing is written there.
+	if opts.AbsWorkingDir, err = filepath.Abs(dir); err != nil {
+		return nil, nil, err
+	}
+	opts.EntryPoints = []string{file}
+	opts.Bundle = true
+	opts.Outfile = filepath.Join(opts.AbsWorkingDir, filepath.FromSlash(out))
+	opts.AllowOverwrite = true
+	opts.LogLevel = api.LogLevelSilent
+	if s.cfg.Pipeline.SourceMaps {
+		opts.Sourcemap = api.SourceMapExternal
+	}
+
+	res := api.Build(opts)
+	if len(res.Errors) > 0 {
+		e := res.Errors[0]
+		if e.Location != nil {
+			return nil, nil, fmt.Errorf("%s:%d:%d: %s", filepath.Join(dir, e.Location.File), e.Location.Line, e.Location.Column+1, e.Text)
+		}
+		return nil, nil, fmt.Errorf("%s: %s", file, e.Text)
+	}
+	ext := path.Ext(out)
+	for _, f := range res.OutputFiles {
+		switch {
+		case strings.HasSuffix(f.Path, ext):
+			b = f.Contents
+		case strings.HasSuffix(f.Path, ext+".map"):
+			sourceMap = f.Contents
+		}
+	}
+
+	return b, sourceMap, nil
+}
@@ -0,0 +1,218 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.astrophena.name/gen/fileutil"
+)
+
+// redirect is a redirect from an alias to the page URL. Both are paths
+// relative to the host without the path of the base URL.
+type redirect struct {
+	from, to  string
+	file      string // alias output file
+	canonical string // absolute page URL, if base_url is set
+}
+
+// redirectFormats write redirects in formats of web servers and hosting
+// services.
+var redirectFormats = map[string]struct {
+	file  string
+	write func(s *Site, w *bytes.Buffer, rs []*redirect) error
+}{
+	// https://docs.netlify.com/routing/redirects/
+	"netlify": {"_redirects", func(s *Site, w *bytes.Buffer, rs []*redirect) error {
+		return s.writeRedirects(w, rs, "%s %s 301\n")
+	}},
+	// Include the file in the http block and redirect with:
+	//
+	//	if ($redirect_uri) { return 301 $redirect_uri; }
+	"nginx": {"redirects.nginx.conf", func(s *Site, w *bytes.Buffer, rs []*redirect) error {
+		w.WriteString("map $uri $redirect_uri {\n")
+		if err := s.writeRedirects(w, rs, "    %s %s;\n"); err != nil {
+			return err
+		}
+		w.WriteString("}\n")
+		return nil
+	}},
+	"apache": {".htaccess", func(s *Site, w *bytes.Buffer, rs []*redirect) error {
+		return s.writeRedirects(w, rs, "Redirect 301 %s %s\n")
+	}},
+}
+
+func validRedirectFormat(name string) error {
+	if _, ok := redirectFormats[name]; ok {
+		return nil
+	}
+	var names []string
+	for name := range redirectFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown redirects format %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// writeRedirects writes redirects to w with the path of the base URL,
+// formatting each with format.
+func (s *Site) writeRedirects(w *bytes.Buffer, rs []*redirect, format string) error {
+	for _, r := range rs {
+		from, err := s.relURL(r.from)
+		if err != nil {
+			return err
+		}
+		to, err := s.relURL(r.to)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, format, from, to)
+	}
+	return nil
+}
+
+// collectRedirects collects redirects from aliases of site pages.
+func (s *Site) collectRedirects() error {
+	s.redirects = make(map[string]*redirect)
+
+	files := make(map[string]bool)
+	for _, p := range s.pages {
+		files[strings.TrimPrefix(p.URI, "/")] = true
+	}
+
+	for _, p := range s.pages {
+		to := "/" + uriPath(p.URI)
+		var canonical string
+		if s.cfg.BaseURL != "" {
+			u, err := s.absURL(uriPath(p.URI))
+			if err != nil {
+				return err
+			}
+			canonical = u
+		}
+
+		for _, alias := range p.Aliases {
+			file := normalizeURI(strings.TrimPrefix(alias, "/"))
+			if outsideDir(file) {
+				return fmt.Errorf("%s: alias %s is outside of the output directory", s.sourceFile(p), alias)
+			}
+			file = strings.TrimPrefix(path.Clean("/"+file), "/")
+			if files[file] {
+				return fmt.Errorf("%s: alias %s conflicts with a page", s.sourceFile(p), alias)
+			}
+			if other, ok := s.redirects[file]; ok {
+				return fmt.Errorf("%s: alias %s already redirects to %s", s.sourceFile(p), alias, other.to)
+			}
+
+			s.redirects[file] = &redirect{
+				from:      "/" + uriPath(file),
+				to:        to,
+				file:      file,
+				canonical: canonical,
+			}
+		}
+	}
+
+	return nil
+}
+
+var aliasTemplate = template.Must(template.New("alias").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{ .URL }}</title>
+<link rel="canonical" href="{{ .URL }}">
+<meta name="robots" content="noindex">
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{ .URL }}">
+</head>
+</html>
+`))
+
+// buildRedirects writes redirect pages for aliases and redirects files
+// of configured formats, appending to existing ones.
+func (s *Site) buildRedirects() error {
+	var rs []*redirect
+	for _, file := range sortedRedirectFiles(s.redirects) {
+		r := s.redirects[file]
+		rs = append(rs, r)
+
+		u := r.canonical
+		if u == "" {
+			var err error
+			if u, err = s.relURL(r.to); err != nil {
+				return err
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := aliasTemplate.Execute(&buf, struct{ URL string }{u}); err != nil {
+			return err
+		}
+
+		dst := filepath.Join(s.dst, filepath.FromSlash(file))
+		if err := fileutil.Mkdir(filepath.Dir(dst)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if len(rs) == 0 {
+		return nil
+	}
+
+	for _, name := range s.cfg.Redirects {
+		f := redirectFormats[name]
+
+		// Redirects are appended to the file from the static directory,
+		// if there is one.
+		file := filepath.Join(s.dst, f.file)
+		var buf bytes.Buffer
+		b, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		buf.Write(b)
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+
+		if err := f.write(s, &buf, rs); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedRedirectFiles(m map[string]*redirect) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lookupRedirect returns a redirect for the request path, if any.
+func (s *Site) lookupRedirect(p string) (*redirect, bool) {
+	file := strings.TrimPrefix(path.Clean(p), "/")
+	if strings.HasSuffix(p, "/") || path.Ext(file) == "" {
+		file = path.Join(file, "index.html")
+	}
+	r, ok := s.redirects[file]
+	return r, ok
+}
@@ -0,0 +1,33 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeRedirects(t *testing.T) {
+	s := &Site{
+		dst: t.TempDir(),
+		redirects: map[string]*redirect{
+			"old/path/index.html": {from: "/old/path/", to: "/posts/new/"},
+			"older.html":          {from: "/older.html", to: "/posts/new/"},
+		},
+	}
+
+	for _, p := range []string{"/old/path/", "/old/path", "/old/path/index.html", "/older.html"} {
+		w := httptest.NewRecorder()
+		s.fs().ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: got status %d, want %d", p, w.Code, http.StatusMovedPermanently)
+		}
+		if loc := w.Header().Get("Location"); loc != "/posts/new/" {
+			t.Errorf("%s: got location %q, want /posts/new/", p, loc)
+		}
+	}
+}
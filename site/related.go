@@ -0,0 +1,169 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultRelatedLimit is a maximum number of related pages that is
+// used when none is configured.
+const DefaultRelatedLimit = 5
+
+// RelatedConfig configures related pages.
+type RelatedConfig struct {
+	// Limit is a maximum number of related pages of a page. Default is
+	// 5.
+	Limit int `yaml:"limit"`
+
+	// Threshold is a minimum score of a related page.
+	Threshold int `yaml:"threshold"`
+
+	// Indices are what pages are related by. Default is tags (weight
+	// 100), keywords (80) and categories (50).
+	Indices []*RelatedIndex `yaml:"indices"`
+}
+
+// RelatedIndex is a page field that relates pages, such as tags.
+type RelatedIndex struct {
+	// Name is "tags", "categories", "keywords", "title" (words of the
+	// page title) or a name of the page parameter.
+	Name string `yaml:"name"`
+
+	// Weight is added to the score of a related page for every shared
+	// value.
+	Weight int `yaml:"weight"`
+}
+
+var defaultRelatedIndices = []*RelatedIndex{
+	{Name: "tags", Weight: 100},
+	{Name: "keywords", Weight: 80},
+	{Name: "categories", Weight: 50},
+}
+
+// buildRelated finds related pages of every site page. Pages are scored
+// with an inverted index of values of each index, so only pages that
+// share something are considered.
+func (s *Site) buildRelated() {
+	cfg := s.cfg.Related
+	if cfg.Limit <= 0 {
+		cfg.Limit = DefaultRelatedLimit
+	}
+	if len(cfg.Indices) == 0 {
+		cfg.Indices = defaultRelatedIndices
+	}
+
+	// terms[i][j] are values of index i of page j.
+	terms := make([][][]string, len(cfg.Indices))
+	inverted := make([]map[string][]int, len(cfg.Indices))
+	for i, idx := range cfg.Indices {
+		terms[i] = make([][]string, len(s.pages))
+		inverted[i] = make(map[string][]int)
+		for j, p := range s.pages {
+			terms[i][j] = p.relatedTerms(idx.Name)
+			for _, t := range terms[i][j] {
+				inverted[i][t] = append(inverted[i][t], j)
+			}
+		}
+	}
+
+	for j, p := range s.pages {
+		scores := make(map[int]int)
+		for i, idx := range cfg.Indices {
+			for _, t := range terms[i][j] {
+				for _, k := range inverted[i][t] {
+					if k != j {
+						scores[k] += idx.Weight
+					}
+				}
+			}
+		}
+
+		related := make([]int, 0, len(scores))
+		for k, score := range scores {
+			if score > 0 && score >= cfg.Threshold {
+				related = append(related, k)
+			}
+		}
+		sort.Slice(related, func(a, b int) bool {
+			if scores[related[a]] != scores[related[b]] {
+				return scores[related[a]] > scores[related[b]]
+			}
+			// Pages are sorted already.
+			return related[a] < related[b]
+		})
+		if len(related) > cfg.Limit {
+			related = related[:cfg.Limit]
+		}
+
+		p.related = nil
+		for _, k := range related {
+			p.related = append(p.related, s.pages[k])
+		}
+	}
+}
+
+// Related returns pages related to the page, the most related first.
+func (p *Page) Related() []*Page { return p.related }
+
+// relatedTerms returns unique normalized values of the page field name.
+func (p *Page) relatedTerms(name string) []string {
+	var values []string
+	switch name {
+	case "tags":
+		values = p.Tags
+	case "categories":
+		values = p.Categories
+	case "keywords":
+		values = p.Keywords
+	case "title":
+		values = titleWords(p.Title)
+	default:
+		switch v := p.value("Params." + name).(type) {
+		case string:
+			values = []string{v}
+		case []interface{}:
+			for _, e := range v {
+				values = append(values, toString(e))
+			}
+		}
+	}
+
+	var (
+		terms []string
+		seen  = make(map[string]bool)
+	)
+	for _, v := range values {
+		t := strings.ToLower(strings.TrimSpace(v))
+		if t != "" && !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// stopWords are common English words that don't relate titles.
+var stopWords = map[string]bool{
+	"about": true, "and": true, "are": true, "for": true, "from": true,
+	"how": true, "into": true, "not": true, "that": true, "the": true,
+	"this": true, "what": true, "when": true, "why": true, "with": true,
+	"you": true, "your": true,
+}
+
+// titleWords returns significant words of the title.
+func titleWords(title string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) > 2 && !stopWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
@@ -0,0 +1,275 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// ShortcodesDir is a directory inside the templates directory that
+// contains shortcode templates.
+const ShortcodesDir = "shortcodes"
+
+const (
+	shortcodeOpen  = "{{<"
+	shortcodeClose = ">}}"
+)
+
+// Shortcode is passed to a shortcode template as data.
+//
+// Shortcodes are written in page content as {{< name arg key="value" >}},
+// or as {{< name >}}inner content{{< /name >}}. Each shortcode is backed
+// by a template from the shortcodes directory, e.g.
+// templates/shortcodes/youtube.tmpl.
+type Shortcode struct {
+	Name   string            // shortcode name
+	Args   []string          // positional arguments
+	Params map[string]string // named arguments
+	Inner  string            // content between opening and closing tags
+	Page   *Page             // page that contains the shortcode
+}
+
+// Get returns a positional argument by index or a named argument by
+// name. It returns an empty string for missing arguments.
+func (sc *Shortcode) Get(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case int:
+		if k < 0 || k >= len(sc.Args) {
+			return "", nil
+		}
+		return sc.Args[k], nil
+	case string:
+		return sc.Params[k], nil
+	}
+	return "", fmt.Errorf("shortcode %s: invalid argument key %v", sc.Name, key)
+}
+
+// shortcodes expands shortcodes in page content.
+//
+// Rendered shortcodes are replaced with placeholders, so Markdown
+// rendering doesn't mangle them. The returned function puts them back
+// into the rendered content.
+func (s *Site) shortcodes(p *Page, content string) (string, func(string) string, error) {
+	var placeholders []string
+
+	expanded, err := s.expandShortcodes(p, content, &placeholders)
+	if err != nil {
+		return "", nil, err
+	}
+
+	restore := func(html string) string {
+		if len(placeholders) == 0 {
+			return html
+		}
+		var oldnew []string
+		for i, out := range placeholders {
+			ph := shortcodePlaceholder(i)
+			// Shortcodes that are standing on their own line end up
+			// wrapped into paragraphs by Markdown.
+			oldnew = append(oldnew, "<p>"+ph+"</p>", out, ph, out)
+		}
+		return strings.NewReplacer(oldnew...).Replace(html)
+	}
+
+	return expanded, restore, nil
+}
+
+func shortcodePlaceholder(i int) string {
+	return fmt.Sprintf("GENSHORTCODE%04dX", i)
+}
+
+// expandShortcodes renders shortcodes in text. If placeholders is not
+// nil, rendered shortcodes are appended to it and replaced with
+// placeholders in the returned text.
+func (s *Site) expandShortcodes(p *Page, text string, placeholders *[]string) (string, error) {
+	var out strings.Builder
+
+	for {
+		start := strings.Index(text, shortcodeOpen)
+		if start < 0 {
+			out.WriteString(text)
+			return out.String(), nil
+		}
+		out.WriteString(text[:start])
+
+		tag, rest, err := nextShortcodeTag(text[start:])
+		if err != nil {
+			return "", err
+		}
+		text = rest
+
+		// {{</* name */>}} is written as is, without the comment.
+		if strings.HasPrefix(tag, "/*") && strings.HasSuffix(tag, "*/") {
+			fmt.Fprintf(&out, "%s %s %s", shortcodeOpen, strings.TrimSpace(tag[2:len(tag)-2]), shortcodeClose)
+			continue
+		}
+
+		if strings.HasPrefix(tag, "/") {
+			return "", fmt.Errorf("unexpected closing shortcode %s", tag)
+		}
+
+		selfClosing := strings.HasSuffix(tag, "/")
+		sc, err := parseShortcodeTag(strings.TrimSuffix(tag, "/"))
+		if err != nil {
+			return "", err
+		}
+		sc.Page = p
+
+		if !selfClosing {
+			if inner, after, ok := findClosingShortcode(text, sc.Name); ok {
+				sc.Inner, err = s.expandShortcodes(p, inner, nil)
+				if err != nil {
+					return "", err
+				}
+				text = after
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := s.tpl.ExecuteTemplate(&buf, path.Join(ShortcodesDir, sc.Name), sc); err != nil {
+			return "", fmt.Errorf("shortcode %s: %w", sc.Name, err)
+		}
+
+		if placeholders == nil {
+			out.WriteString(buf.String())
+			continue
+		}
+		out.WriteString(shortcodePlaceholder(len(*placeholders)))
+		*placeholders = append(*placeholders, buf.String())
+	}
+}
+
+// nextShortcodeTag returns contents of the shortcode tag at the
+// beginning of text and text after the tag.
+func nextShortcodeTag(text string) (tag, rest string, err error) {
+	end := strings.Index(text, shortcodeClose)
+	if end < 0 {
+		return "", "", fmt.Errorf("unclosed shortcode tag %.20q", text)
+	}
+	return strings.TrimSpace(text[len(shortcodeOpen):end]), text[end+len(shortcodeClose):], nil
+}
+
+// findClosingShortcode looks for the tag that closes the shortcode
+// name in text, returning text before and after it.
+func findClosingShortcode(text, name string) (inner, after string, ok bool) {
+	var (
+		depth int
+		pos   int
+	)
+	for {
+		start := strings.Index(text[pos:], shortcodeOpen)
+		if start < 0 {
+			return "", "", false
+		}
+		start += pos
+
+		tag, rest, err := nextShortcodeTag(text[start:])
+		if err != nil {
+			return "", "", false
+		}
+		pos = len(text) - len(rest)
+
+		switch {
+		case tag == "/"+name:
+			if depth == 0 {
+				return text[:start], rest, true
+			}
+			depth--
+		case !strings.HasSuffix(tag, "/") && strings.HasPrefix(tag, name) &&
+			(len(tag) == len(name) || unicode.IsSpace(rune(tag[len(name)]))):
+			depth++
+		}
+	}
+}
+
+// parseShortcodeTag parses contents of the shortcode tag, such as
+// `youtube id autoplay="true"`.
+func parseShortcodeTag(tag string) (*Shortcode, error) {
+	fields, err := splitShortcodeArgs(tag)
+	if err != nil {
+		return nil, fmt.Errorf("shortcode %q: %w", tag, err)
+	}
+	if len(fields) == 0 || fields[0].named {
+		return nil, fmt.Errorf("shortcode %q: missing name", tag)
+	}
+
+	sc := &Shortcode{Name: fields[0].value, Params: make(map[string]string)}
+	for _, f := range fields[1:] {
+		if f.named {
+			sc.Params[f.key] = f.value
+			continue
+		}
+		sc.Args = append(sc.Args, f.value)
+	}
+	return sc, nil
+}
+
+type shortcodeArg struct {
+	named bool
+	key   string
+	value string
+}
+
+// splitShortcodeArgs splits the shortcode tag into space-separated
+// arguments. Values can be quoted with double quotes or backticks.
+func splitShortcodeArgs(tag string) ([]shortcodeArg, error) {
+	var (
+		args []shortcodeArg
+		r    = []rune(tag)
+	)
+	for i := 0; i < len(r); {
+		if unicode.IsSpace(r[i]) {
+			i++
+			continue
+		}
+
+		var arg shortcodeArg
+
+		// key=
+		if j := i; r[j] != '"' && r[j] != '`' {
+			for j < len(r) && !unicode.IsSpace(r[j]) && r[j] != '=' {
+				j++
+			}
+			if j < len(r) && r[j] == '=' {
+				arg.named, arg.key = true, string(r[i:j])
+				i = j + 1
+			}
+		}
+
+		// value
+		if i < len(r) && (r[i] == '"' || r[i] == '`') {
+			quote := r[i]
+			j := i + 1
+			for j < len(r) && r[j] != quote {
+				if r[j] == '\\' && quote == '"' && j+1 < len(r) {
+					j++
+				}
+				j++
+			}
+			if j >= len(r) {
+				return nil, fmt.Errorf("unterminated quoted value")
+			}
+			arg.value = string(r[i+1 : j])
+			if quote == '"' {
+				arg.value = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(arg.value)
+			}
+			i = j + 1
+		} else {
+			j := i
+			for j < len(r) && !unicode.IsSpace(r[j]) {
+				j++
+			}
+			arg.value = string(r[i:j])
+			i = j
+		}
+
+		args = append(args, arg)
+	}
+	return args, nil
+}
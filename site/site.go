@@ -10,30 +10,21 @@ import (
 	"context"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
-	"mime"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
 	"path/filepath"
-	"regexp"
+	"reflect"
 	"strings"
 	"syscall"
 	"time"
 
 	"go.astrophena.name/gen/fileutil"
 	"go.astrophena.name/gen/frontmatter"
-	"go.astrophena.name/gen/version"
 
-	"github.com/russross/blackfriday/v2"
 	"github.com/tdewolff/minify"
-	"github.com/tdewolff/minify/css"
-	"github.com/tdewolff/minify/html"
-	"github.com/tdewolff/minify/js"
-	"github.com/tdewolff/minify/json"
-	"github.com/tdewolff/minify/svg"
 )
 
 // SupportedFormats contains supported page formats.
@@ -42,13 +33,39 @@ var SupportedFormats = []string{".html", ".md"}
 // TemplateExt is a template file extension.
 const TemplateExt = ".tmpl"
 
+// Directories that can be provided by both the site and its themes.
+const (
+	StaticDir    = "static"
+	TemplatesDir = "templates"
+)
+
 // Site represents a site.
 type Site struct {
-	pages    []*Page
-	minify   bool
-	src, dst string
-	tpl      *template.Template
-	quiet    bool
+	cfg           *Config
+	md            Markdown
+	pages         []*Page
+	sources       map[string]*Page     // pages by source file path, relative to the pages directory
+	redirects     map[string]*redirect // redirects by alias output file
+	uris          map[string]string    // page URIs to their sources
+	menus         Menus
+	lang          string                            // language of the site view, see buildLanguages
+	views         map[string]*Site                  // site views by language
+	allPages      []*Page                           // pages in all languages
+	i18n          map[string]map[string]interface{} // template strings by language
+	curLang       string                            // language of the page being built
+	translations  map[string][]*Page                // pages by translation key
+	static        map[string]bool                   // static files, relative to static directories
+	assets        map[string]*Asset                 // static files by their original path
+	fingerprinted map[string]bool                   // fingerprinted static files
+	manifest      map[string]string                 // fingerprinted file paths by original paths
+	bundles       map[string]*Asset                 // bundled stylesheets and scripts by entry
+	minify        bool
+	m             *minify.M // minifiers, made once per build
+	src, dst      string
+	themes        []string             // theme directories, from the site theme to the most basic one
+	tpl           *template.Template   // shared templates, such as partials
+	layouts       map[string][]*layout // layouts by template name
+	quiet         bool
 }
 
 func (s *Site) logf(format string, args ...interface{}) {
@@ -64,23 +81,57 @@ func New(src, dst string, quiet, minify bool) (*Site, error) {
 		s   = &Site{src: src, dst: dst, quiet: quiet, minify: minify}
 	)
 
-	for _, dir := range []string{s.pagesDir(), s.templatesDir()} {
+	s.cfg, err = loadConfig(filepath.Join(s.src, ConfigFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.Theme != "" {
+		s.themes, err = s.loadThemes(s.cfg.Theme)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A theme can provide templates.
+	required := []string{s.pagesDir()}
+	if len(s.themes) == 0 {
+		required = append(required, filepath.Join(s.src, TemplatesDir))
+	}
+	for _, dir := range required {
 		if !fileutil.Exists(dir) {
 			return nil, fmt.Errorf("%s: does not exist, this directory is required", dir)
 		}
 	}
 
-	s.tpl, err = parseTemplates(s.templatesDir())
+	s.md, err = newMarkdown(&s.cfg.Markdown)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := s.parseTemplates(); err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
+// Pages returns pages of the site in its language. It's populated
+// during Build.
+func (s *Site) Pages() []*Page { return s.pages }
+
+// AllPages returns pages of the site in all languages.
+func (s *Site) AllPages() []*Page { return s.allPages }
+
 // Build builds the site.
 func (s *Site) Build() error {
 	start := time.Now()
+	s.pages = nil
+	s.m = newMinifier(&s.cfg.Minify)
+
+	if err := s.loadI18n(); err != nil {
+		return err
+	}
 
 	if err := s.Clean(); err != nil {
 		return err
@@ -90,20 +141,28 @@ func (s *Site) Build() error {
 		return err
 	}
 
-	if fileutil.Exists(s.staticDir()) {
+	if static := s.lookupDirs(StaticDir); len(static) > 0 {
 		s.logf("Copying static files...")
 
-		if s.minify {
-			if err := minifyStaticFiles(s.staticDir(), s.dst); err != nil {
-				return err
-			}
-		} else {
-			if err := fileutil.CopyDirContents(s.staticDir(), s.dst); err != nil {
-				return err
+		// Copy themes first, so the site files override them.
+		for i := len(static) - 1; i >= 0; i-- {
+			if s.minify {
+				if err := s.minifyStaticFiles(static[i]); err != nil {
+					return err
+				}
+			} else {
+				if err := fileutil.CopyDirContents(static[i], s.dst); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
+	if err := s.fingerprintStatic(); err != nil {
+		return err
+	}
+	s.bundles = make(map[string]*Asset)
+
 	pages, err := fileutil.Files(s.pagesDir(), SupportedFormats...)
 	if err != nil {
 		return err
@@ -117,12 +176,79 @@ func (s *Site) Build() error {
 		}
 	}
 
+	// Frontmatter of all pages is parsed before rendering content, so
+	// links between pages can be resolved.
+	var parsed []*Page
+	s.sources = make(map[string]*Page)
+	s.translations = make(map[string][]*Page)
+	s.uris = make(map[string]string)
 	for _, pp := range pages {
 		p, err := s.parsePage(pp)
 		if err != nil {
 			return err
 		}
-		s.pages = append(s.pages, p)
+		parsed = append(parsed, p)
+		s.sources[p.path] = p
+		if p.Generate == "" {
+			if t := p.translation(p.Lang); t != nil {
+				return fmt.Errorf("%s: page is already translated to %s in %s", pp, p.Lang, s.sourceFile(t))
+			}
+			s.translations[p.key] = append(s.translations[p.key], p)
+			s.pages = append(s.pages, p)
+			s.uris[p.URI] = pp
+		}
+	}
+
+	if err := s.collectBundles(parsed); err != nil {
+		return err
+	}
+
+	var all []*Page
+	for _, p := range parsed {
+		if err := s.renderPage(p); err != nil {
+			return err
+		}
+
+		if p.Generate != "" {
+			gp, err := s.generate(&Generator{
+				Data:        p.Generate,
+				URI:         p.URI,
+				Title:       p.Title,
+				Description: p.Description,
+				Template:    p.Template,
+			}, p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", s.sourceFile(p), err)
+			}
+			all = append(all, gp...)
+			continue
+		}
+
+		all = append(all, p)
+	}
+	s.pages = all
+
+	for _, g := range s.cfg.Generators {
+		gp, err := s.generate(g, &Page{})
+		if err != nil {
+			return err
+		}
+		s.pages = append(s.pages, gp...)
+	}
+
+	if err := s.linkResources(); err != nil {
+		return err
+	}
+
+	sortPages(s.pages)
+	s.allPages = s.pages
+
+	if err := s.buildLanguages(); err != nil {
+		return err
+	}
+
+	if err := s.collectRedirects(); err != nil {
+		return err
 	}
 
 	for _, p := range s.pages {
@@ -131,6 +257,18 @@ func (s *Site) Build() error {
 		}
 	}
 
+	if err := s.buildRedirects(); err != nil {
+		return err
+	}
+
+	if err := s.writeManifest(); err != nil {
+		return err
+	}
+
+	if err := s.compressOutput(); err != nil {
+		return err
+	}
+
 	s.logf("Built in %v.", time.Since(start))
 
 	return nil
@@ -163,7 +301,7 @@ func (s *Site) Serve(addr string) error {
 
 	var (
 		errc = make(chan error)
-		stop = make(chan os.Signal)
+		stop = make(chan os.Signal, 1)
 	)
 
 	signal.Notify(stop, os.Interrupt)
@@ -188,24 +326,35 @@ func (s *Site) Serve(addr string) error {
 
 		return srv.Shutdown(ctx)
 	}
-
-	return nil
 }
 
-func (s *Site) pagesDir() string     { return filepath.Join(s.src, "pages") }
-func (s *Site) staticDir() string    { return filepath.Join(s.src, "static") }
-func (s *Site) templatesDir() string { return filepath.Join(s.src, "templates") }
+func (s *Site) dataDir() string   { return filepath.Join(s.src, "data") }
+func (s *Site) pagesDir() string  { return filepath.Join(s.src, "pages") }
+func (s *Site) themesDir() string { return filepath.Join(s.src, "themes") }
 
 func (s *Site) fs() http.Handler {
 	dir := http.Dir(s.dst)
 	fs := http.FileServer(dir)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := dir.Open(path.Clean(r.URL.Path))
+		if rd, ok := s.lookupRedirect(r.URL.Path); ok {
+			http.Redirect(w, r, rd.to, http.StatusMovedPermanently)
+			return
+		}
+
+		p := path.Clean(r.URL.Path)
+		_, err := dir.Open(p)
 		if os.IsNotExist(err) {
 			s.notFound(w, r)
 			return
 		}
+		// Fingerprinted files change names when they change.
+		if s.fingerprinted[strings.TrimPrefix(p, "/")] {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		if s.serveGzip(w, r, r.URL.Path) {
+			return
+		}
 		fs.ServeHTTP(w, r)
 	})
 }
@@ -228,8 +377,129 @@ type Page struct {
 	Description string            `yaml:"description"`
 	MetaTags    map[string]string `yaml:"meta_tags"`
 	Template    string            `yaml:"template"`
+	Date        time.Time         `yaml:"date"`
+
+	// Params contains arbitrary page parameters. For generated pages
+	// it contains the data file record.
+	Params map[string]interface{} `yaml:"params"`
+
+	// Generate is a data file, relative to the data directory. If set,
+	// the page is used as a prototype for generating a page per record
+	// and its uri, title and description are treated as templates.
+	Generate string `yaml:"generate"`
+
+	// Summary is a short version of the content. If not set in
+	// frontmatter (as Markdown), it's the content before the summary
+	// divider, or the first words of the content.
+	Summary template.HTML `yaml:"summary"`
+
+	// Weight orders pages and menu entries: lighter ones go first,
+	// ones without weight go last.
+	Weight int `yaml:"weight"`
+
+	// Tags, Categories and Keywords relate pages to each other.
+	Tags       []string `yaml:"tags"`
+	Categories []string `yaml:"categories"`
+	Keywords   []string `yaml:"keywords"`
+
+	// Menu lists menus the page is added to.
+	Menu pageMenus `yaml:"menu"`
+
+	// Aliases are old URLs of the page, that redirect to it.
+	Aliases []string `yaml:"aliases"`
+
+	// Lang is a code of the page language. It's set by the name of the
+	// page source file or its top-level directory.
+	Lang string `yaml:"-"`
+
+	// Headings is a tree of Markdown content headings that are
+	// included in the table of contents.
+	Headings []*Heading `yaml:"-"`
 
-	s *Site // reference to the page owner
+	s     *Site  // reference to the page owner
+	path  string // source file path, relative to the pages directory
+	key   string // path without the language, shared by translations
+	raw   string // content before rendering
+	plain string // content without HTML tags
+
+	links map[string][]string // resolved links to page source files, by URL
+
+	bundle    []bundleFile // files of the page bundle
+	resources Resources
+
+	parent   *Page
+	children []*Page
+	related  []*Page
+
+	// Neighbors in .Site.Pages and in the section.
+	prev, next                   *Page
+	prevInSection, nextInSection *Page
+}
+
+// Site returns the site that the page belongs to, in the page
+// language.
+func (p *Page) Site() *Site {
+	if v, ok := p.s.views[p.Lang]; ok {
+		return v
+	}
+	return p.s
+}
+
+// Kind returns a kind of the page: "home" for the home page, "404"
+// for the page that is served when nothing is found and "page" for
+// all other pages.
+func (p *Page) Kind() string {
+	uri := strings.TrimPrefix(p.URI, "/")
+	if p.s != nil && p.Lang != "" && p.Lang != p.s.defaultLanguage() {
+		uri = strings.TrimPrefix(uri, p.Lang+"/")
+	}
+	switch uri {
+	case "index.html":
+		return "home"
+	case "404.html":
+		return "404"
+	}
+	return "page"
+}
+
+// TableOfContents returns the table of contents of the page as nested
+// lists of links to headings.
+func (p *Page) TableOfContents() template.HTML {
+	return tableOfContents(p.Headings)
+}
+
+// Section returns a top-level directory of the page source file
+// under the pages directory, or an empty string for pages at the top
+// level.
+func (p *Page) Section() string {
+	if i := strings.Index(p.key, "/"); i > 0 {
+		return p.key[:i]
+	}
+	return ""
+}
+
+// value returns a value of the page field key, e.g. "Title". Page
+// parameters are accessed as "Params.name"; a key that is not a field
+// name is looked up in parameters too.
+func (p *Page) value(key string) interface{} {
+	key = strings.TrimPrefix(key, ".")
+
+	if !strings.HasPrefix(key, "Params.") {
+		f := reflect.ValueOf(p).Elem().FieldByName(key)
+		if f.IsValid() && f.CanInterface() {
+			return f.Interface()
+		}
+	}
+
+	var v interface{} = p.Params
+	for _, k := range strings.Split(strings.TrimPrefix(key, "Params."), ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
 }
 
 // Build builds a site page to dst.
@@ -241,7 +511,17 @@ func (p *Page) Build() error {
 
 	var buf bytes.Buffer
 
-	if err := p.s.tpl.ExecuteTemplate(&buf, p.Template, p); err != nil {
+	p.s.curLang = p.Lang
+	l, err := p.s.layout(p.Template)
+	if err != nil {
+		return err
+	}
+
+	if err := l.execute(&buf, p); err != nil {
+		return err
+	}
+
+	if err := p.copyResources(); err != nil {
 		return err
 	}
 
@@ -251,13 +531,8 @@ func (p *Page) Build() error {
 	}
 	defer f.Close()
 
-	if p.s.minify {
-		m := minify.New()
-		m.Add("text/html", &html.Minifier{
-			KeepDocumentTags: true,
-			KeepEndTags:      true,
-		})
-		return m.Minify("text/html", f, &buf)
+	if p.s.minify && !p.s.cfg.Minify.excluded(p.URI) {
+		return p.s.m.Minify("text/html", f, &buf)
 	}
 
 	if _, err := buf.WriteTo(f); err != nil {
@@ -281,117 +556,120 @@ func (s *Site) parsePage(src string) (*Page, error) {
 		return nil, fmt.Errorf("%s: failed to parse frontmatter: %w", src, err)
 	}
 
-	if s.tpl.Lookup(p.Template) == nil {
-		return nil, fmt.Errorf("%s: the template %s specified is not defined", src, p.Template)
+	p.Params = stringMap(p.Params)
+
+	if p.Title == "" || p.URI == "" {
+		return nil, fmt.Errorf("%s: missing required frontmatter parameter (title, uri)", src)
 	}
 
-	if p.Title == "" || p.Template == "" || p.URI == "" {
-		return nil, fmt.Errorf("%s: missing required frontmatter parameter (title, template, uri)", src)
+	rel, err := filepath.Rel(s.pagesDir(), src)
+	if err != nil {
+		return nil, err
 	}
+	p.path = filepath.ToSlash(rel)
+	p.Lang, p.key = s.pageLanguage(p.path)
+
+	// Generated pages get their URIs and templates on generation.
+	if p.Generate == "" {
+		p.URI = normalizeURI(s.languageURI(p.Lang, p.URI))
 
-	if !strings.HasSuffix(p.URI, ".html") {
-		p.URI = p.URI + "/index.html"
+		if err := s.resolveTemplate(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", src, err)
+		}
 	}
 
-	switch filepath.Ext(src) {
-	case ".html":
-		p.Content = c
-	case ".md":
-		p.Content = string(blackfriday.Run([]byte(c)))
-	default:
+	if ext := filepath.Ext(src); ext != ".html" && ext != ".md" {
 		return nil, fmt.Errorf("%s: format does not supported", src)
 	}
+	p.raw = c
 
 	return p, nil
 }
 
-// parseTemplates parses templates from dir and returns a template
-// that is used for generating pages.
-func parseTemplates(dir string) (*template.Template, error) {
-	tpls, err := fileutil.Files(dir, TemplateExt)
+// renderPage expands shortcodes and renders Markdown in the page
+// content.
+func (s *Site) renderPage(p *Page) error {
+	src := s.sourceFile(p)
+	s.curLang = p.Lang
+
+	c, restore, err := s.shortcodes(p, p.raw)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("%s: %w", src, err)
 	}
 
-	if len(tpls) < 1 {
-		return nil, fmt.Errorf("no templates found in %s", dir)
-	}
+	// The summary divider is cut out before rendering, so both the
+	// content and the summary are well-formed.
+	summary, c, divided := cutSummary(c)
 
-	tpl := template.New("site").Funcs(template.FuncMap{
-		"content": func(p *Page) template.HTML {
-			return template.HTML(p.Content)
-		},
-		"year": func() int {
-			return time.Now().Year()
-		},
-		"version": func() string {
-			return version.Version
-		},
-	})
+	content, headings, err := s.renderContent(p, c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	p.Content = restore(content)
+	if headings != nil {
+		p.Headings = headingTree(headings, s.cfg.Markdown.TOC)
+	}
 
-	for _, t := range tpls {
-		b, err := os.ReadFile(t)
+	// A summary from frontmatter takes precedence over the divider.
+	if divided && p.Summary == "" {
+		summary, _, err = s.renderContent(p, summary)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("%s: %w", src, err)
 		}
+		summary = restore(summary)
+	}
 
-		tpl, err = tpl.Parse(string(b))
-		if err != nil {
-			return nil, err
-		}
+	if err := s.summarize(p, summary, divided); err != nil {
+		return fmt.Errorf("%s: %w", src, err)
 	}
 
-	return tpl, nil
+	return nil
 }
 
-func minifyStaticFiles(src, dst string) error {
-	files, err := fileutil.Files(src)
+// renderContent renders the page content c, with shortcodes expanded,
+// according to the page source file format.
+func (s *Site) renderContent(p *Page, c string) (string, []*Heading, error) {
+	if path.Ext(p.path) != ".md" {
+		return c, nil, nil
+	}
+	doc, err := s.md.Render([]byte(c), s.renderContext(p))
 	if err != nil {
-		return err
+		return "", nil, err
 	}
+	return string(doc.HTML), doc.Headings, nil
+}
 
-	m := minify.New()
-	m.AddFunc("text/css", css.Minify)
-	m.AddFuncRegexp(regexp.MustCompile("^(application|text)/(x-)?(java|ecma)script$"), js.Minify)
-	m.AddFuncRegexp(regexp.MustCompile("[/+]json$"), json.Minify)
-	m.AddFunc("image/svg+xml", svg.Minify)
-
-	for _, file := range files {
-		mimetype := mime.TypeByExtension(filepath.Ext(file))
-
-		from, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer from.Close()
-
-		dir, err := filepath.Rel(src, file)
-		if err != nil {
-			return err
-		}
-		dir = filepath.Dir(dir)
-
-		if err := fileutil.Mkdir(filepath.Join(dst, dir)); err != nil {
-			return err
-		}
-
-		to, err := os.Create(filepath.Join(dst, dir, filepath.Base(file)))
-		if err != nil {
-			return err
-		}
-		defer to.Close()
+// cutSummary splits the content c at the summary divider and returns
+// the content before it and c without it. A divider on its own line is
+// cut with the line break.
+func cutSummary(c string) (summary, content string, ok bool) {
+	i := strings.Index(c, SummaryDivider)
+	if i < 0 {
+		return "", c, false
+	}
+	end := i + len(SummaryDivider)
+	if (i == 0 || c[i-1] == '\n') && end < len(c) && c[end] == '\n' {
+		end++
+	}
+	return c[:i], c[:i] + c[end:], true
+}
 
-		err = m.Minify(mimetype, to, from)
-		if err != nil {
-			if err != minify.ErrNotExist {
-				return err
-			}
+// sourceFile returns a path of the page source file.
+func (s *Site) sourceFile(p *Page) string {
+	return filepath.Join(s.pagesDir(), filepath.FromSlash(p.path))
+}
 
-			if _, err := io.Copy(to, from); err != nil {
-				return err
-			}
-		}
+// normalizeURI returns a path of the output file for uri.
+func normalizeURI(uri string) string {
+	if !strings.HasSuffix(uri, ".html") {
+		return strings.TrimSuffix(uri, "/") + "/index.html"
 	}
+	return uri
+}
 
-	return nil
+// outsideDir reports whether the slash-separated path rel, relative to
+// the output directory, points outside of it.
+func outsideDir(rel string) bool {
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	return rel == ".." || strings.HasPrefix(rel, "../")
 }
@@ -1,7 +1,24 @@
 package site_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"go.astrophena.name/gen/scaffold"
@@ -33,3 +50,1021 @@ func TestBuild(t *testing.T) {
 		t.Fatalf("Failed to build a site: %v", err)
 	}
 }
+
+// buildSite writes files to a temporary directory, builds a site from
+// it and returns a directory with the built site.
+func buildSite(t *testing.T, files map[string]string) (dst string) {
+	t.Helper()
+
+	src, dst := writeFiles(t, files), t.TempDir()
+
+	s, err := site.New(src, dst, true, false)
+	if err != nil {
+		t.Fatalf("Failed to initialize a new site: %v", err)
+	}
+
+	if err := s.Build(); err != nil {
+		t.Fatalf("Failed to build a site: %v", err)
+	}
+
+	return dst
+}
+
+func writeFiles(t *testing.T, files map[string]string) (dir string) {
+	t.Helper()
+
+	dir = t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readFile reads a file from the built site.
+func readFile(t *testing.T, dst, name string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGenerators(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": `generators:
+  - data: people.yaml
+    uri: /people/{{ .slug }}/
+    title: "{{ .name }}"
+    template: layout
+`,
+		"data/products.csv": "slug,name,price\nlamp,Desk Lamp,10\nchair,Chair,25\n",
+		"data/people.yaml":  "- slug: ada\n  name: Ada Lovelace\n  links: {home: ada.example}\n",
+		"pages/products.md": `---
+title: "{{ .name }}"
+template: layout
+uri: /products/{{ .slug }}/
+generate: products.csv
+---
+Product page.
+`,
+		"templates/layout.tmpl": `{{ define "layout" }}{{ .Title }}|{{ .Params.price }}|{{ with .Params.links }}{{ .home }}{{ end }}|{{ content . }}{{ end }}`,
+	})
+
+	for name, want := range map[string]string{
+		"products/lamp/index.html":  "Desk Lamp|10||<p>Product page.</p>\n",
+		"products/chair/index.html": "Chair|25||<p>Product page.</p>\n",
+		"people/ada/index.html":     "Ada Lovelace||ada.example|",
+	} {
+		if got := readFile(t, dst, name); strings.TrimSpace(got) != strings.TrimSpace(want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	const page = "---\ntitle: \"{{ .slug }}\"\nuri: /products/{{ .slug }}/\ngenerate: products.csv\n---\n"
+
+	for name, tc := range map[string]struct {
+		files map[string]string
+		want  string
+	}{
+		"outside of the output directory": {
+			files: map[string]string{
+				"data/products.csv": "slug\n../../../escaped\n",
+				"pages/products.md": page,
+			},
+			want: "record 1: uri /products/../../../escaped/index.html is outside of the output directory",
+		},
+		"duplicate record": {
+			files: map[string]string{
+				"data/products.csv": "slug\nlamp\nlamp\n",
+				"pages/products.md": page,
+			},
+			want: "record 2: uri /products/lamp/index.html is already used by ",
+		},
+		"page with the same uri": {
+			files: map[string]string{
+				"data/products.csv": "slug\nlamp\n",
+				"pages/products.md": page,
+				"pages/lamp.md":     "---\ntitle: Lamp\nuri: /products/lamp/\n---\n",
+			},
+			want: "record 1: uri /products/lamp/index.html is already used by ",
+		},
+		"duplicate generator": {
+			files: map[string]string{
+				"gen.yaml":          "generators:\n  - data: products.csv\n    uri: /products/{{ .slug }}\n    title: \"{{ .slug }}\"\n",
+				"data/products.csv": "slug\nlamp\n",
+				"pages/products.md": page,
+			},
+			want: "record 1: uri /products/lamp/index.html is already used by ",
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.files["templates/_default.tmpl"] = "{{ .Title }}"
+			s, err := site.New(writeFiles(t, tc.files), t.TempDir(), true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Build(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTemplateLookup(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"pages/index.md":                     "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/about.md":                     "---\ntitle: About\nuri: about\n---\n",
+		"pages/special.md":                   "---\ntitle: Special\nuri: special\n---\n",
+		"pages/docs/install.md":              "---\ntitle: Install\nuri: docs/install\n---\n",
+		"pages/blog/hello.md":                "---\ntitle: Hello\nuri: blog/hello\n---\n",
+		"pages/blog/explicit.md":             "---\ntitle: Explicit\nuri: blog/explicit\ntemplate: special\n---\n",
+		"templates/home.tmpl":                `home:{{ partial "title" . }}`,
+		"templates/special.tmpl":             `special:{{ partial "title" . }}`,
+		"templates/docs.tmpl":                `docs:{{ partial "title" . }}`,
+		"templates/_default.tmpl":            `default:{{ partial "title" . }}`,
+		"templates/partials/title.tmpl":      `{{ .Title }}{{ partial "nested/dot" }}`,
+		"templates/partials/nested/dot.tmpl": `.`,
+	})
+
+	for name, want := range map[string]string{
+		"index.html":               "home:Home.",
+		"about/index.html":         "default:About.",
+		"special/index.html":       "special:Special.",
+		"docs/install/index.html":  "docs:Install.",
+		"blog/hello/index.html":    "default:Hello.",
+		"blog/explicit/index.html": "special:Explicit.",
+	} {
+		if got := readFile(t, dst, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBaseTemplate(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"pages/index.md":             "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/post.md":              "---\ntitle: Post\nuri: post\ntemplate: post\n---\n",
+		"pages/plain.md":             "---\ntitle: Plain\nuri: plain\ntemplate: plain\n---\n",
+		"pages/docs/guide.md":        "---\ntitle: Guide\nuri: docs/guide\ntemplate: docs/page\n---\n",
+		"templates/baseof.tmpl":      `({{ block "title" . }}{{ .Title }}{{ end }}){{ block "main" . }}default{{ end }}`,
+		"templates/home.tmpl":        `{{ define "main" }}home{{ end }}`,
+		"templates/post.tmpl":        `{{ define "main" }}post{{ end }}{{ define "title" }}Post: {{ .Title }}{{ end }}`,
+		"templates/plain.tmpl":       `{{ define "unused" }}{{ end }}`,
+		"templates/docs/baseof.tmpl": `[{{ block "main" . }}{{ end }}]`,
+		"templates/docs/page.tmpl":   `{{ define "main" }}docs{{ end }}`,
+	})
+
+	for name, want := range map[string]string{
+		"index.html":            "(Home)home",
+		"post/index.html":       "(Post: Post)post",
+		"plain/index.html":      "(Plain)default",
+		"docs/guide/index.html": "[docs]",
+	} {
+		if got := readFile(t, dst, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestTemplateErrorNamesFile(t *testing.T) {
+	for name, tc := range map[string]struct {
+		template string
+		files    map[string]string
+		file     string
+		want     string
+	}{
+		"layout": {
+			template: "home",
+			files: map[string]string{
+				"templates/baseof.tmpl": `{{ block "main" . }}{{ end }}`,
+				"templates/home.tmpl":   `{{ define "main" }}{{ .Missing }}{{ end }}`,
+			},
+			file: filepath.Join("templates", "home.tmpl"),
+			want: "template: home:1",
+		},
+		"base template": {
+			template: "docs/home",
+			files: map[string]string{
+				"templates/docs/baseof.tmpl": `{{ .Missing }}{{ block "main" . }}{{ end }}`,
+				"templates/docs/home.tmpl":   `{{ define "main" }}{{ end }}`,
+			},
+			file: filepath.Join("templates", "docs", "baseof.tmpl"),
+			want: "template: baseof:1",
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tc.files["pages/index.md"] = "---\ntitle: Home\nuri: index.html\ntemplate: " + tc.template + "\n---\n"
+
+			s, err := site.New(writeFiles(t, tc.files), t.TempDir(), true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Build()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.file+": ") || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %q doesn't name %s", err, tc.file)
+			}
+		})
+	}
+}
+
+func TestThemes(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml":        "theme: house\n",
+		"pages/index.md":  "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/about.md":  "---\ntitle: About\nuri: about\n---\n",
+		"static/site.css": "site",
+
+		"themes/base/templates/baseof.tmpl":          `{{ partial "header" . }}|{{ block "main" . }}{{ end }}`,
+		"themes/base/templates/_default.tmpl":        `{{ define "main" }}base default{{ end }}`,
+		"themes/base/templates/partials/header.tmpl": `base header`,
+		"themes/base/static/base.css":                "base",
+		"themes/base/static/site.css":                "base",
+
+		"themes/house/theme.yaml":                     "inherits: base\n",
+		"themes/house/templates/home.tmpl":            `{{ define "main" }}house home{{ end }}`,
+		"themes/house/templates/partials/header.tmpl": `house header`,
+		"themes/house/static/house.css":               "house",
+		"themes/house/static/base.css":                "house",
+	})
+
+	for name, want := range map[string]string{
+		"index.html":       "house header|house home",
+		"about/index.html": "house header|base default",
+		"base.css":         "house",
+		"house.css":        "house",
+		"site.css":         "site",
+	} {
+		if got := readFile(t, dst, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"pages/index.md": `---
+title: Home
+uri: index.html
+---
+Intro.
+
+{{< youtube abc123 autoplay="true" >}}
+
+{{< note type="warning" >}}Be *careful* with {{< kbd Ctrl >}}.{{< /note >}}
+
+Inline {{< kbd "Alt Gr" />}} key and {{</* kbd X */>}} example.
+`,
+		"templates/_default.tmpl":           `{{ content . }}`,
+		"templates/shortcodes/youtube.tmpl": `<div class="video"><iframe src="https://www.youtube.com/embed/{{ .Get 0 }}?autoplay={{ .Get "autoplay" }}"></iframe></div>`,
+		"templates/shortcodes/note.tmpl":    `<aside class="{{ .Params.type }}">{{ markdownify .Inner }} ({{ .Page.Title }})</aside>`,
+		"templates/shortcodes/kbd.tmpl":     `<kbd>{{ index .Args 0 }}</kbd>`,
+	})
+
+	want := `<p>Intro.</p>
+
+<div class="video"><iframe src="https://www.youtube.com/embed/abc123?autoplay=true"></iframe></div>
+
+<aside class="warning">Be <em>careful</em> with <kbd>Ctrl</kbd>. (Home)</aside>
+
+<p>Inline <kbd>Alt Gr</kbd> key and {{&lt; kbd X &gt;}} example.</p>
+`
+	if got := readFile(t, dst, "index.html"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTableOfContents(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": `markdown:
+  headings:
+    anchors: true
+  toc:
+    min_depth: 2
+`,
+		"pages/index.md": `---
+title: Home
+uri: index.html
+---
+# Home
+
+## Install
+
+## Usage
+`,
+		"templates/_default.tmpl": `{{ .TableOfContents }}`,
+	})
+
+	want := `<nav class="toc"><ul><li><a href="#install">Install</a></li><li><a href="#usage">Usage</a></li></ul></nav>`
+	if got := readFile(t, dst, "index.html"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderHooks(t *testing.T) {
+	for _, engine := range []string{"blackfriday", "goldmark"} {
+		t.Run(engine, func(t *testing.T) {
+			testRenderHooks(t, engine)
+		})
+	}
+}
+
+func testRenderHooks(t *testing.T, engine string) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": "markdown:\n  engine: " + engine + "\n",
+		"pages/index.md": "---\ntitle: Home\nuri: index.html\n---\n" +
+			"## Hi *there*\n\n" +
+			"[Site](https://example.com) and [about](/about/).\n\n" +
+			"![A cat](cat.png \"Meow\")\n\n" +
+			"```mermaid\ngraph TD;\n```\n\n" +
+			"```go {title=\"main.go\"}\npackage main\n```\n",
+		"templates/_default.tmpl":                       `{{ content . }}`,
+		"templates/render-hooks/heading.tmpl":           `(h{{ .Level }} id={{ .ID }}){{ .Text }}(/h{{ .Level }})`,
+		"templates/render-hooks/link.tmpl":              `<a href="{{ .Destination }}" rel="noopener">{{ .Text }}</a>`,
+		"templates/render-hooks/image.tmpl":             `<figure><img src="{{ .Destination }}" alt="{{ .PlainText }}"><figcaption>{{ .Title }}</figcaption></figure>`,
+		"templates/render-hooks/codeblock.tmpl":         `<pre data-title="{{ .Attributes.title }}">{{ .Inner }}</pre>`,
+		"templates/render-hooks/codeblock-mermaid.tmpl": `<div class="mermaid">{{ .Inner }}</div>`,
+	})
+
+	got := readFile(t, dst, "index.html")
+	for _, want := range []string{
+		`(h2 id=hi-there)Hi <em>there</em>(/h2)`,
+		`<a href="https://example.com" rel="noopener">Site</a> and <a href="/about/" rel="noopener">about</a>.`,
+		`<figure><img src="cat.png" alt="A cat"><figcaption>Meow</figcaption></figure>`,
+		`<div class="mermaid">graph TD;` + "\n" + `</div>`,
+		`<pre data-title="main.go">package main` + "\n" + `</pre>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q doesn't contain %q", got, want)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	files := map[string]string{
+		"gen.yaml":       "base_url: https://example.com/\n",
+		"pages/about.md": "---\ntitle: About\nuri: about\n---\n[Install](docs/setup.md#install)",
+		"pages/docs/setup.md": "---\ntitle: Setup\nuri: docs/setup\n---\n" +
+			"[About](../about.md), [home](/index.md), [external](https://example.org/x.md).\n\n" +
+			`{{< ref "../about.md" >}}`,
+		"pages/index.md":                "---\ntitle: Home\nuri: index.html\n---\n",
+		"templates/_default.tmpl":       `{{ content . }}`,
+		"templates/shortcodes/ref.tmpl": `{{ ref .Page (.Get 0) }} {{ relref .Page "/docs/setup.md#top" }}`,
+	}
+	dst := buildSite(t, files)
+
+	if got, want := readFile(t, dst, "about/index.html"), `<p><a href="/docs/setup/#install">Install</a></p>`+"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got := readFile(t, dst, "docs/setup/index.html")
+	for _, want := range []string{
+		`<a href="/about/">About</a>`,
+		`<a href="/">home</a>`,
+		`<a href="https://example.org/x.md">external</a>`,
+		`https://example.com/about/ /docs/setup/#top`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q doesn't contain %q", got, want)
+		}
+	}
+
+	files["pages/about.md"] = "---\ntitle: About\nuri: about\n---\n[Missing](missing.md)"
+	s, err := site.New(writeFiles(t, files), t.TempDir(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err == nil || !strings.Contains(err.Error(), "about.md: link to nonexistent page missing.md") {
+		t.Errorf("got error %v, want a nonexistent page error", err)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src := writeFiles(t, map[string]string{
+		"gen.yaml": "base_url: https://example.com/\n",
+		"pages/index.md": "---\ntitle: Home\nuri: index.html\n---\n# Intro\n\n" +
+			"[About](/about/), [intro](#intro), [canonical](https://example.com/about/).\n\n" +
+			"[Missing](/missing/) and [fragment](/about/#nope).\n\n" +
+			"![Image](/cat.png)\n\n" +
+			"[OK](" + srv.URL + "/ok), [gone](" + srv.URL + "/gone) and [mail](mailto:me@example.com).\n\n" +
+			"[Source](about.md#gone)\n",
+		"pages/about.md":          "---\ntitle: About\nuri: about\n---\nAbout.",
+		"templates/_default.tmpl": "<a href=\"/nav/\">Nav</a>\n{{ content . }}",
+	})
+
+	for _, tc := range []struct {
+		minify bool
+		want   []string
+	}{
+		{
+			want: []string{
+				"about.md (about/index.html:1): /nav/: not found",
+				"index.md (index.html:1): /nav/: not found",
+				"index.md:9 (index.html:6): /missing/: not found",
+				"index.md:9 (index.html:6): /about/#nope: fragment #nope not found",
+				"index.md:11 (index.html:8): /cat.png: not found",
+				"index.md:13 (index.html:10): " + srv.URL + "/gone: 404 Not Found",
+				"index.md:15 (index.html:12): /about/#gone: fragment #gone not found",
+			},
+		},
+		{
+			// Lines of minified files aren't reported.
+			minify: true,
+			want: []string{
+				"about.md (about/index.html): /nav/: not found",
+				"index.md (index.html): /nav/: not found",
+				"index.md:9 (index.html): /missing/: not found",
+				"index.md:9 (index.html): /about/#nope: fragment #nope not found",
+				"index.md:11 (index.html): /cat.png: not found",
+				"index.md:13 (index.html): " + srv.URL + "/gone: 404 Not Found",
+				"index.md:15 (index.html): /about/#gone: fragment #gone not found",
+			},
+		},
+	} {
+		s, err := site.New(src, t.TempDir(), true, tc.minify)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		broken, err := s.CheckLinks(site.CheckLinksOptions{External: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, l := range broken {
+			got = append(got, l.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("minify %v: got %q, want %q", tc.minify, got, tc.want)
+		}
+	}
+}
+
+func TestAliases(t *testing.T) {
+	files := map[string]string{
+		"gen.yaml":                "base_url: https://example.com/blog/\nredirects: [netlify, nginx, apache]\n",
+		"pages/new.md":            "---\ntitle: New\nuri: posts/new\naliases: [/old/path/, /older.html]\n---\nNew.",
+		"static/_redirects":       "/legacy/* /new/:splat 301",
+		"templates/_default.tmpl": "{{ content . }}",
+	}
+	src := writeFiles(t, files)
+	dst := t.TempDir()
+	s, err := site.New(src, dst, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{"old/path/index.html", "older.html"} {
+		got := readFile(t, dst, file)
+		for _, want := range []string{
+			`<link rel="canonical" href="https://example.com/blog/posts/new/">`,
+			`<meta http-equiv="refresh" content="0; url=https://example.com/blog/posts/new/">`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q doesn't contain %q", file, got, want)
+			}
+		}
+	}
+
+	for file, want := range map[string]string{
+		"_redirects":           "/legacy/* /new/:splat 301\n/blog/old/path/ /blog/posts/new/ 301\n/blog/older.html /blog/posts/new/ 301\n",
+		"redirects.nginx.conf": "map $uri $redirect_uri {\n    /blog/old/path/ /blog/posts/new/;\n    /blog/older.html /blog/posts/new/;\n}\n",
+		".htaccess":            "Redirect 301 /blog/old/path/ /blog/posts/new/\nRedirect 301 /blog/older.html /blog/posts/new/\n",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+
+	files["pages/new.md"] = "---\ntitle: New\nuri: posts/new\naliases: [/../../escaped/]\n---\nNew."
+	s, err = site.New(writeFiles(t, files), t.TempDir(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err == nil || !strings.Contains(err.Error(), "alias /../../escaped/ is outside of the output directory") {
+		t.Errorf("got error %v, want an alias outside of the output directory error", err)
+	}
+}
+
+func TestSummaries(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml":                "summary_length: 3\nwords_per_minute: 4\n",
+		"pages/index.md":          "---\ntitle: Home\nuri: index.html\n---\nFirst *part*.\n\n<!--more-->\n\nSecond part & more.",
+		"pages/auto.md":           "---\ntitle: Auto\nuri: auto\n---\nOne two three four five.\n\n<script>var x;</script>\n",
+		"pages/set.md":            "---\ntitle: Set\nuri: set\nsummary: Set *in* frontmatter.\n---\nContent.",
+		"pages/list.md":           "---\ntitle: List\nuri: list\ntemplate: content\n---\n- One\n- Two\n<!--more-->\n- Three\n",
+		"templates/_default.tmpl": `{{ .Summary }}|{{ .WordCount }}|{{ .ReadingTime }}|{{ .Plain }}`,
+		"templates/content.tmpl":  `{{ .Summary }}|{{ content . }}`,
+	})
+
+	for file, want := range map[string]string{
+		"index.html":      "<p>First <em>part</em>.</p>|6|2|First part. Second part &amp; more.",
+		"list/index.html": "<ul>\n<li>One</li>\n<li>Two</li>\n</ul>|<ul>\n<li>One</li>\n<li>Two</li>\n<li>Three</li>\n</ul>\n",
+		"auto/index.html": "One two three|5|2|One two three four five.",
+		"set/index.html":  "Set <em>in</em> frontmatter.|1|1|Content.",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestMenus(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": `menus:
+  main:
+    - name: Home
+      page: index.md
+      weight: 1
+    - name: GitHub
+      url: https://github.com/example
+  footer:
+    - name: Docs
+      url: /docs/
+`,
+		"pages/index.md":        "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/docs/index.md":   "---\ntitle: Docs\nuri: docs\nmenu: main\nweight: 2\n---\n",
+		"pages/docs/install.md": "---\ntitle: Install\nuri: docs/install\nmenu:\n  main:\n    parent: Docs\n    name: Installation\n---\n",
+		"pages/docs/usage.md":   "---\ntitle: Usage\nuri: docs/usage\nweight: 1\nmenu:\n  main:\n    parent: Docs\n---\n",
+		"templates/_default.tmpl": `{{ $p := . }}{{ define "menu" }}{{ range .Menu }}[{{ .Name }} {{ .URL }}{{ if .IsActive $.Page }} active{{ end }}{{ if .HasChildren }} {{ template "menu" (dict "Menu" .Children "Page" $.Page) }}{{ end }}]{{ end }}{{ end }}` +
+			`{{ template "menu" (dict "Menu" .Site.Menus.main "Page" $p) }} {{ template "menu" (dict "Menu" .Site.Menus.footer "Page" $p) }}`,
+	})
+
+	for file, want := range map[string]string{
+		"index.html":              "[Home / active][Docs /docs/ [Usage /docs/usage/][Installation /docs/install/]][GitHub https://github.com/example] [Docs /docs/]",
+		"docs/install/index.html": "[Home /][Docs /docs/ active [Usage /docs/usage/][Installation /docs/install/ active]][GitHub https://github.com/example] [Docs /docs/]",
+		"docs/index.html":         "[Home /][Docs /docs/ active [Usage /docs/usage/][Installation /docs/install/]][GitHub https://github.com/example] [Docs /docs/ active]",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestPageOrder(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"pages/index.md":           "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/tutorial/intro.md":  "---\ntitle: Intro\nuri: tutorial/intro\nweight: 1\n---\n",
+		"pages/tutorial/next.md":   "---\ntitle: Next Steps\nuri: tutorial/next\nweight: 3\n---\n",
+		"pages/tutorial/basics.md": "---\ntitle: Basics\nuri: tutorial/basics\nweight: 2\n---\n",
+		"pages/blog/old.md":        "---\ntitle: Old\nuri: blog/old\ndate: 2020-01-01T00:00:00Z\n---\n",
+		"pages/blog/new.md":        "---\ntitle: New\nuri: blog/new\ndate: 2021-01-01T00:00:00Z\n---\n",
+		"templates/_default.tmpl":  `{{ range .Site.Pages }}{{ .Title }},{{ end }}`,
+		"templates/tutorial.tmpl":  `{{ with .PrevInSection }}← {{ .Title }}{{ end }}|{{ with .NextInSection }}{{ .Title }} →{{ end }}`,
+		"templates/blog.tmpl":      `{{ with .Prev }}{{ .Title }}{{ end }}|{{ with .Next }}{{ .Title }}{{ end }}`,
+	})
+
+	for file, want := range map[string]string{
+		"index.html":                 "Intro,Basics,Next Steps,New,Old,Home,",
+		"tutorial/intro/index.html":  "|Basics →",
+		"tutorial/basics/index.html": "← Intro|Next Steps →",
+		"tutorial/next/index.html":   "← Basics|",
+		"blog/new/index.html":        "Next Steps|Old",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestPageTree(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"pages/index.md":               "---\ntitle: Home\nuri: index.html\n---\n",
+		"pages/about.md":               "---\ntitle: About\nuri: about\n---\n",
+		"pages/docs/index.md":          "---\ntitle: Docs\nuri: docs\n---\n",
+		"pages/docs/setup/install.md":  "---\ntitle: Install\nuri: docs/setup/install\nweight: 1\n---\n",
+		"pages/docs/usage.md":          "---\ntitle: Usage\nuri: docs/usage\nweight: 2\n---\n",
+		"pages/docs/usage-advanced.md": "---\ntitle: Advanced\nuri: docs/usage/advanced\nweight: 3\n---\n",
+		"templates/_default.tmpl":      `{{ range .Ancestors }}{{ .Title }} / {{ end }}{{ .Title }}|{{ with .Parent }}{{ .Title }}{{ end }}|{{ range .Children }}{{ .Title }},{{ end }}`,
+	})
+
+	for file, want := range map[string]string{
+		"index.html":                     "Home||About,Docs,",
+		"about/index.html":               "Home / About|Home|",
+		"docs/index.html":                "Home / Docs|Home|Install,Usage,Advanced,",
+		"docs/setup/install/index.html":  "Home / Docs / Install|Docs|",
+		"docs/usage/advanced/index.html": "Home / Docs / Advanced|Docs|",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestRelated(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": `related:
+  limit: 2
+  indices:
+    - name: tags
+      weight: 10
+    - name: series
+      weight: 5
+    - name: title
+      weight: 1
+`,
+		"pages/go-tips.md":        "---\ntitle: Go Tips\nuri: go-tips\ntags: [go, tips]\n---\n",
+		"pages/go-testing.md":     "---\ntitle: Testing in Go\nuri: go-testing\ntags: [Go, testing]\n---\n",
+		"pages/more-tips.md":      "---\ntitle: More Tips\nuri: more-tips\ntags: [tips]\nparams:\n  series: [tips]\n---\n",
+		"pages/rust-tips.md":      "---\ntitle: Rust Tips\nuri: rust-tips\nparams:\n  series: [tips]\n---\n",
+		"pages/unrelated.md":      "---\ntitle: Unrelated\nuri: unrelated\n---\n",
+		"templates/_default.tmpl": `{{ range .Related }}{{ .Title }},{{ end }}`,
+	})
+
+	for file, want := range map[string]string{
+		"go-tips/index.html":   "More Tips,Testing in Go,",
+		"more-tips/index.html": "Go Tips,Rust Tips,",
+		"rust-tips/index.html": "More Tips,Go Tips,",
+		"unrelated/index.html": "",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml": `languages:
+  en:
+    name: English
+    weight: 1
+  ru:
+    name: Русский
+    weight: 2
+    menus:
+      main:
+        - page: about.md
+`,
+		"i18n/en.yaml":      "hello: Hello\nmore: More\nminutes:\n  one: \"{{ . }} minute\"\n  other: \"{{ . }} minutes\"\n",
+		"i18n/ru.yaml":      "hello: Привет\nminutes:\n  one: \"{{ . }} минута\"\n  other: \"{{ . }} минут\"\n",
+		"pages/index.md":    "---\ntitle: Home\nuri: /\n---\n",
+		"pages/about.md":    "---\ntitle: About\nuri: about\nmenu: main\n---\n[home](index.md)\n",
+		"pages/about.ru.md": "---\ntitle: О сайте\nuri: about\n---\n[home](index.md)\n",
+		"pages/ru/index.md": "---\ntitle: Главная\nuri: /\n---\n",
+		"templates/_default.tmpl": `{{ .Lang }} {{ .Kind }}|` +
+			`{{ range .Translations }}{{ .Language.Name }}={{ .URI }},{{ end }}|` +
+			`{{ i18n "hello" }},{{ i18n "more" }},{{ i18n "minutes" 1 }},{{ i18n "minutes" 5 }},{{ i18n "missing" }}|` +
+			`{{ range .Site.Pages }}{{ .Title }},{{ end }}|{{ len .Site.AllPages }}|` +
+			`{{ range .Site.Menus.main }}{{ .Name }}={{ .URL }},{{ end }}|` +
+			`{{ with .Parent }}{{ .Title }}{{ end }}|{{ content . }}`,
+	})
+
+	for file, want := range map[string]string{
+		"index.html":          "en home|Русский=ru/index.html,|Hello,More,1 minute,5 minutes,missing|About,Home,|4|About=/about/,||",
+		"about/index.html":    "en page|Русский=ru/about/index.html,|Hello,More,1 minute,5 minutes,missing|About,Home,|4|About=/about/,|Home|<p><a href=\"/\">home</a></p>\n",
+		"ru/index.html":       "ru home|English=/index.html,|Привет,More,1 минута,5 минут,missing|Главная,О сайте,|4|О сайте=/ru/about/,||",
+		"ru/about/index.html": "ru page|English=about/index.html,|Привет,More,1 минута,5 минут,missing|Главная,О сайте,|4|О сайте=/ru/about/,|Главная|<p><a href=\"/ru/\">home</a></p>\n",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestBundles(t *testing.T) {
+	files := map[string]string{
+		"gen.yaml":                       "languages:\n  ru: {}\n",
+		"pages/index.md":                 "---\ntitle: Home\nuri: /\n---\n",
+		"pages/stray.txt":                "stray",
+		"pages/blog/post/index.md":       "---\ntitle: Post\nuri: blog/post\n---\n![cat](cat.jpg)\n",
+		"pages/blog/post/index.ru.md":    "---\ntitle: Пост\nuri: blog/post\n---\n",
+		"pages/blog/post/cat.jpg":        "cat",
+		"pages/blog/post/images/dog.png": "dog",
+		"pages/blog/post/notes.txt":      "notes",
+		"pages/blog/post/.hidden":        "hidden",
+		"pages/blog/post/old/index.md":   "---\ntitle: Old\nuri: blog/post/old.html\n---\n",
+		"pages/blog/post/old/data.json":  "{}",
+		"templates/_default.tmpl": `{{ range .Resources }}{{ .Name }}={{ .URL }} {{ .MediaType }},{{ end }}|` +
+			`{{ with .Resources.Get "notes.txt" }}{{ .Content }}{{ end }}|` +
+			`{{ range .Resources.Match "*.jpg" }}{{ .Name }},{{ end }}|` +
+			`{{ range .Resources.ByType "image" }}{{ .Name }},{{ end }}`,
+	}
+	dst := buildSite(t, files)
+
+	for file, want := range map[string]string{
+		"index.html":               "|||",
+		"blog/post/index.html":     "cat.jpg=/blog/post/cat.jpg image/jpeg,images/dog.png=/blog/post/images/dog.png image/png,notes.txt=/blog/post/notes.txt text/plain,|notes|cat.jpg,|cat.jpg,images/dog.png,",
+		"ru/blog/post/index.html":  "cat.jpg=/ru/blog/post/cat.jpg image/jpeg,images/dog.png=/ru/blog/post/images/dog.png image/png,notes.txt=/ru/blog/post/notes.txt text/plain,|notes|cat.jpg,|cat.jpg,images/dog.png,",
+		"blog/post/old.html":       "data.json=/blog/post/data.json application/json,|||",
+		"blog/post/cat.jpg":        "cat",
+		"blog/post/images/dog.png": "dog",
+		"ru/blog/post/cat.jpg":     "cat",
+		"blog/post/data.json":      "{}",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+
+	for _, file := range []string{"blog/post/.hidden", "stray.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, file)); !os.IsNotExist(err) {
+			t.Errorf("%s is copied: %v", file, err)
+		}
+	}
+
+	// Resources of blog/post/old.html are written next to it.
+	files["pages/blog/post/data.json"] = "[]"
+	s, err := site.New(writeFiles(t, files), t.TempDir(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err == nil || !strings.Contains(err.Error(), "resource data.json is written to blog/post/data.json, as ") {
+		t.Errorf("got error %v, want a resource collision error", err)
+	}
+}
+
+func TestImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+
+	src := writeFiles(t, map[string]string{
+		"pages/post/index.md":  "---\ntitle: Post\nuri: post\n---\n",
+		"pages/post/photo.png": buf.String(),
+		"templates/_default.tmpl": `{{ $img := .Resources.Get "photo.png" }}` +
+			`{{ define "size" }}{{ .MediaType }} {{ .Width }}x{{ .Height }}{{ end }}` +
+			`{{ template "size" $img }},` +
+			`{{ template "size" ($img.Resize "100x") }},` +
+			`{{ template "size" ($img.Resize "x50 jpg q90 nearest") }},` +
+			`{{ template "size" ($img.Fit "100x100") }},` +
+			`{{ template "size" ($img.Fill "100x100 top") }},` +
+			`{{ template "size" ($img.Crop "50x300") }}|` +
+			`{{ ($img.Resize "100x").URL }}|` +
+			`{{ srcset $img "A photo" 100 500 }}`,
+	})
+	dst := t.TempDir()
+
+	s, err := site.New(src, dst, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(readFile(t, dst, "post/index.html"), "|")
+	if len(parts) != 3 {
+		t.Fatalf("got %q", parts)
+	}
+
+	if want := "image/png 400x200,image/png 100x50,image/jpeg 100x50,image/png 100x50,image/png 100x100,image/png 50x200"; parts[0] != want {
+		t.Errorf("got sizes %q, want %q", parts[0], want)
+	}
+
+	if !regexp.MustCompile(`^/post/photo_[0-9a-f]{10}\.png$`).MatchString(parts[1]) {
+		t.Errorf("got URL %q", parts[1])
+	}
+	f, err := os.Open(filepath.Join(dst, filepath.FromSlash(parts[1])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if c, _, err := image.DecodeConfig(f); err != nil || c.Width != 100 || c.Height != 50 {
+		t.Errorf("processed image: got %dx%d, %v", c.Width, c.Height, err)
+	}
+
+	wantSrcset := `<img src="/post/photo.png" srcset="` + parts[1] + ` 100w, /post/photo.png 400w" width="400" height="200" alt="A photo">`
+	if parts[2] != wantSrcset {
+		t.Errorf("got srcset %q, want %q", parts[2], wantSrcset)
+	}
+
+	// Processed images are cached.
+	cached, err := filepath.Glob(filepath.Join(src, "resources", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 5 {
+		t.Errorf("got %d cached images, want 5", len(cached))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	const css = "body { color: red; }"
+
+	dst := buildSite(t, map[string]string{
+		"gen.yaml":                "fingerprint:\n  files: [\"*.css\"]\n",
+		"static/css/sitewide.css": css,
+		"static/robots.txt":       "User-agent: *",
+		"pages/index.html":        "---\ntitle: Home\nuri: /\n---\n",
+		"templates/_default.tmpl": `{{ with asset "css/sitewide.css" }}<link href="{{ .URL }}" integrity="{{ .Integrity }}">{{ end }}|{{ (asset "/robots.txt").URL }}`,
+	})
+
+	sha := sha256.Sum256([]byte(css))
+	hashed := "css/sitewide." + hex.EncodeToString(sha[:4]) + ".css"
+	sri := sha512.Sum384([]byte(css))
+
+	// html/template escapes "+" in attributes.
+	want := `<link href="/` + hashed + `" integrity="` + strings.ReplaceAll("sha384-"+base64.StdEncoding.EncodeToString(sri[:]), "+", "&#43;") + `">|/robots.txt`
+	if got := readFile(t, dst, "index.html"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := readFile(t, dst, hashed); got != css {
+		t.Errorf("%s: got %q, want %q", hashed, got, css)
+	}
+	// The original is kept for links that don't use asset.
+	if got := readFile(t, dst, "css/sitewide.css"); got != css {
+		t.Errorf("original file: got %q, want %q", got, css)
+	}
+
+	wantManifest := "{\n  \"css/sitewide.css\": \"" + hashed + "\"\n}\n"
+	if got := readFile(t, dst, "assets.json"); got != wantManifest {
+		t.Errorf("manifest: got %q, want %q", got, wantManifest)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	dst := buildSite(t, map[string]string{
+		"gen.yaml":                "pipeline:\n  minify: true\n  source_maps: true\nfingerprint:\n  files: [\"*.css\"]\n",
+		"assets/css/main.css":     "@import \"base.css\";\n@import url(print.css);\n@import url(https://example.com/x.css);\n\na {\n  color: blue;\n}\n",
+		"assets/css/base.css":     "body {\n  margin: 0;\n}\n",
+		"assets/css/print.css":    "@media print {\n  body { color: black; }\n}\n",
+		"assets/js/main.js":       "import { greet } from './greet.js';\ngreet('world');\n",
+		"assets/js/greet.js":      "export function greet(name) {\n  console.log(`Hello, ${name}!`);\n}\n",
+		"pages/index.html":        "---\ntitle: Home\nuri: /\n---\n",
+		"templates/_default.tmpl": `{{ (css "css/main.css").URL }}|{{ (js "js/main.js").URL }}|{{ (css "/css/main.css").URL }}`,
+	})
+
+	urls := strings.Split(readFile(t, dst, "index.html"), "|")
+	if len(urls) != 3 {
+		t.Fatalf("got %q", urls)
+	}
+	if !regexp.MustCompile(`^/css/main\.[0-9a-f]{8}\.css$`).MatchString(urls[0]) || urls[2] != urls[0] {
+		t.Errorf("got stylesheet URLs %q and %q", urls[0], urls[2])
+	}
+	if urls[1] != "/js/main.js" {
+		t.Errorf("got script URL %q", urls[1])
+	}
+
+	name := path.Base(urls[0])
+	wantCSS := "@import\"https://example.com/x.css\";body{margin:0}@media print{body{color:#000}}a{color:#00f}\n/*# sourceMappingURL=" + name + ".map */\n"
+	if got := readFile(t, dst, urls[0]); got != wantCSS {
+		t.Errorf("stylesheet: got %q, want %q", got, wantCSS)
+	}
+
+	var sm struct {
+		Sources  []string `json:"sources"`
+		Mappings string   `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(readFile(t, dst, urls[0]+".map")), &sm); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"base.css", "print.css", "main.css"}; !reflect.DeepEqual(sm.Sources, want) {
+		t.Errorf("source map sources: got %q, want %q", sm.Sources, want)
+	}
+	if sm.Mappings == "" {
+		t.Error("source map has no mappings")
+	}
+
+	script := readFile(t, dst, "js/main.js")
+	if !strings.Contains(script, "console.log(`Hello, ${") || !strings.HasSuffix(script, "\n//# sourceMappingURL=main.js.map\n") {
+		t.Errorf("got script %q", script)
+	}
+	readFile(t, dst, "js/main.js.map")
+}
+
+func TestPipelineCSSImports(t *testing.T) {
+	files := map[string]string{
+		"assets/css/main.css":              "@charset \"UTF-8\";\n/* @import \"missing.css\"; */\n@import \"components/button.css\";\n.logo { background: url(logo.png); }\n",
+		"assets/css/components/button.css": "@charset \"UTF-8\";\n@import \"../vendor/reset.css\";\n.btn { background: url(icon.png); }\n.home { background: url(/home.png); }\n",
+		"assets/css/vendor/reset.css":      ".reset { background: url('../img/bg.png') }\n",
+		"pages/index.html":                 "---\ntitle: Home\nuri: /\n---\n",
+		"templates/_default.tmpl":          `{{ (css "css/main.css").URL }}`,
+	}
+	dst := buildSite(t, files)
+
+	want := "@charset \"UTF-8\";\n\n" +
+		"/* css/vendor/reset.css */\n.reset {\n  background: url(img/bg.png);\n}\n\n" +
+		"/* css/components/button.css */\n.btn {\n  background: url(components/icon.png);\n}\n.home {\n  background: url(/home.png);\n}\n\n" +
+		"/* css/main.css */\n.logo {\n  background: url(logo.png);\n}\n"
+	if got := readFile(t, dst, "css/main.css"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// esbuild doesn't bundle imports with media queries.
+	files["assets/css/main.css"] = "@import \"components/button.css\" print;\n"
+	s, err := site.New(writeFiles(t, files), t.TempDir(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err == nil || !strings.Contains(err.Error(), "main.css:1:9: Bundling with conditional \"@import\" rules is not currently supported") {
+		t.Errorf("got error %v, want a conditional import error", err)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("Hello, world! ", 100)
+	dst := buildSite(t, map[string]string{
+		"gen.yaml":                "compress:\n  gzip: true\n  min_size: 512\n",
+		"static/large.css":        large,
+		"static/small.css":        "a{}",
+		"static/large.bin":        large,
+		"pages/index.html":        "---\ntitle: Home\nuri: /\n---\n",
+		"templates/_default.tmpl": large,
+	})
+
+	for name, want := range map[string]bool{
+		"index.html": true,
+		"large.css":  true,
+		"small.css":  false,
+		"large.bin":  false,
+	} {
+		f, err := os.Open(filepath.Join(dst, name+".gz"))
+		if !want {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: got gzipped copy, want none", name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		defer f.Close()
+
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(b) != large {
+			t.Errorf("%s: gzipped copy differs", name)
+		}
+	}
+}
+
+func TestMinify(t *testing.T) {
+	src := writeFiles(t, map[string]string{
+		"gen.yaml": `minify:
+  html:
+    keep_end_tags: false
+    keep_whitespace: true
+  css:
+    decimals: 2
+  exclude: ["*.min.js", "raw/*"]
+`,
+		"static/style.css":        "a {\n  width: 1.23456px;\n}\n",
+		"static/feed.xml":         "<feed>\n  <title>  Feed  </title>\n</feed>\n",
+		"static/lib.min.js":       "var a = 1;\n",
+		"static/app.js":           "var a = 1;\n",
+		"pages/index.html":        "---\ntitle: Home\nuri: /\n---\n",
+		"pages/raw.html":          "---\ntitle: Raw\nuri: raw\n---\n",
+		"templates/_default.tmpl": "<html>\n<body>\n  <p>{{ .Title }}</p>\n  <pre>a\n  b</pre>\n</body>\n</html>\n",
+	})
+	dst := t.TempDir()
+
+	s, err := site.New(src, dst, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		"index.html":     "<html>\n<body>\n<p>Home\n<pre>a\n  b</pre>\n</body>\n</html>",
+		"raw/index.html": "<html>\n<body>\n  <p>Raw</p>\n  <pre>a\n  b</pre>\n</body>\n</html>\n",
+		"style.css":      "a{width:1.23px}",
+		"feed.xml":       "<feed><title>Feed</title></feed>",
+		"lib.min.js":     "var a = 1;\n",
+		"app.js":         "var a=1;",
+	} {
+		if got := readFile(t, dst, file); got != want {
+			t.Errorf("%s: got %q, want %q", file, got, want)
+		}
+	}
+}
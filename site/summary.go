@@ -0,0 +1,114 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"strings"
+
+	parsehtml "github.com/tdewolff/parse/html"
+)
+
+// SummaryDivider separates the summary from the rest of the page
+// content.
+const SummaryDivider = "<!--more-->"
+
+// Defaults of summary and reading time configuration.
+const (
+	DefaultSummaryLength  = 70
+	DefaultWordsPerMinute = 200
+)
+
+// summarize sets the summary and the plain text of the page from its
+// content. A summary from frontmatter is rendered as Markdown and takes
+// precedence over the rendered content before the divider, if the page
+// has one, which takes precedence over the first words of the content.
+func (s *Site) summarize(p *Page, summary string, divided bool) error {
+	p.plain = plainText(p.Content)
+
+	switch {
+	case p.Summary != "":
+		sum, err := s.markdownify(string(p.Summary))
+		if err != nil {
+			return err
+		}
+		p.Summary = sum
+	case divided:
+		p.Summary = template.HTML(strings.TrimSpace(summary))
+	default:
+		n := s.cfg.SummaryLength
+		if n <= 0 {
+			n = DefaultSummaryLength
+		}
+		words := strings.Fields(p.plain)
+		if len(words) > n {
+			words = words[:n]
+		}
+		p.Summary = template.HTML(html.EscapeString(strings.Join(words, " ")))
+	}
+
+	return nil
+}
+
+// Plain returns the page content with HTML tags stripped.
+func (p *Page) Plain() string { return p.plain }
+
+// WordCount returns a number of words in the page content.
+func (p *Page) WordCount() int { return len(strings.Fields(p.plain)) }
+
+// ReadingTime returns an estimated time to read the page content in
+// minutes, rounded up.
+func (p *Page) ReadingTime() int {
+	wpm := DefaultWordsPerMinute
+	if p.s != nil && p.s.cfg.WordsPerMinute > 0 {
+		wpm = p.s.cfg.WordsPerMinute
+	}
+	return (p.WordCount() + wpm - 1) / wpm
+}
+
+// blockTags are tags that separate words of text.
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"figcaption": true, "figure": true, "footer": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "nav": true, "ol": true,
+	"p": true, "pre": true, "section": true, "table": true, "td": true,
+	"th": true, "tr": true, "ul": true,
+}
+
+// plainText returns text of the HTML document without tags, scripts,
+// styles and repeated whitespace.
+func plainText(s string) string {
+	var (
+		b    strings.Builder
+		l    = parsehtml.NewLexer(bytes.NewReader([]byte(s)))
+		skip bool // inside of a script or a style
+	)
+	for {
+		tt, data := l.Next()
+		switch tt {
+		case parsehtml.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case parsehtml.StartTagToken:
+			tag := string(l.Text())
+			skip = tag == "script" || tag == "style"
+			if blockTags[tag] {
+				b.WriteByte(' ')
+			}
+		case parsehtml.EndTagToken:
+			skip = false
+			if blockTags[strings.ToLower(string(l.Text()))] {
+				b.WriteByte(' ')
+			}
+		case parsehtml.TextToken:
+			if !skip {
+				b.WriteString(html.UnescapeString(string(data)))
+			}
+		}
+	}
+}
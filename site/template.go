@@ -0,0 +1,255 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template/parse"
+)
+
+// PartialsDir is a directory inside the templates directory that
+// contains partial templates.
+const PartialsDir = "partials"
+
+// DefaultTemplate is a name of the template that is used when no other
+// template matches a page.
+const DefaultTemplate = "_default"
+
+// BaseTemplate is a name of the base template file, that layouts in
+// the same directory and its subdirectories extend.
+const BaseTemplate = "baseof"
+
+// parseTemplates parses templates from the templates directories of
+// the site and its themes.
+//
+// Each template file is available under its path relative to the
+// templates directory without the extension, e.g. "docs/page" for
+// docs/page.tmpl, along with templates it defines. Site templates
+// override theme templates with the same name.
+//
+// Every layout (a template file that is not a partial, a shortcode, a
+// render hook or a base template) is parsed into its own copy of the
+// template set, together with the nearest base template (baseof.tmpl
+// in the same directory or its parents). That way layouts override
+// blocks of the base template in isolation.
+func (s *Site) parseTemplates() error {
+	dirs := s.lookupDirs(TemplatesDir)
+
+	files, err := layeredFiles(dirs, TemplateExt)
+	if err != nil {
+		return err
+	}
+
+	if len(files) < 1 {
+		return fmt.Errorf("no templates found in %s", strings.Join(dirs, ", "))
+	}
+
+	var (
+		shared  = template.New("site").Funcs(s.funcs())
+		bases   = make(map[string]string) // directory -> base template file
+		layouts []string
+	)
+	for _, rel := range sortedKeys(files) {
+		name, f := strings.TrimSuffix(rel, TemplateExt), files[rel]
+
+		if path.Base(name) == BaseTemplate {
+			bases[path.Dir(name)] = f
+			continue
+		}
+
+		if err := parseFile(shared, name, f); err != nil {
+			return err
+		}
+
+		if isLayout(name) {
+			layouts = append(layouts, name)
+		}
+	}
+
+	s.tpl = shared
+	s.layouts = make(map[string][]*layout)
+
+	for _, name := range layouts {
+		f := files[name+TemplateExt]
+
+		t, err := shared.Clone()
+		if err != nil {
+			return err
+		}
+
+		// Blocks of the base template aren't layouts on their own.
+		blocks := make(map[string]bool)
+		base := nearestBase(bases, path.Dir(name))
+		if base != "" {
+			if err := parseFile(t, BaseTemplate, base); err != nil {
+				return err
+			}
+			for _, bt := range t.Templates() {
+				if bt.Tree != nil && bt.Tree.ParseName == BaseTemplate {
+					blocks[bt.Name()] = true
+				}
+			}
+		}
+
+		// Parse the layout again, so its own definitions take precedence
+		// over the ones from other files and the base template.
+		if err := parseFile(t, name, f); err != nil {
+			return err
+		}
+
+		for _, lt := range t.Templates() {
+			if lt.Tree == nil || lt.Tree.ParseName != name || blocks[lt.Name()] {
+				continue
+			}
+			l := &layout{tpl: t, name: lt.Name(), file: f, base: base}
+			s.layouts[l.name] = append(s.layouts[l.name], l)
+		}
+	}
+
+	return nil
+}
+
+// isLayout reports whether the template name is a layout and not a
+// template from one of special directories.
+func isLayout(name string) bool {
+	for _, dir := range []string{PartialsDir, ShortcodesDir, RenderHooksDir} {
+		if strings.HasPrefix(name, dir+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFile parses the template file into t under name.
+func parseFile(t *template.Template, name, file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	if _, err := t.New(name).Parse(string(b)); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	return nil
+}
+
+// nearestBase returns the base template file for templates in dir.
+func nearestBase(bases map[string]string, dir string) string {
+	for {
+		if base, ok := bases[dir]; ok {
+			return base
+		}
+		if dir == "." || dir == "/" {
+			return ""
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// layout is a template that renders whole pages.
+type layout struct {
+	tpl  *template.Template // a copy of the template set with layout's own definitions
+	name string             // template to execute
+	file string             // template file that defines the layout
+	base string             // base template file, if any
+}
+
+// execute executes the layout with data. If the layout template itself
+// is empty (its file only overrides blocks), the base template is
+// executed instead.
+func (l *layout) execute(w io.Writer, data interface{}) error {
+	name := l.name
+	if t := l.tpl.Lookup(name); (t.Tree == nil || parse.IsEmptyTree(t.Tree.Root)) && l.tpl.Lookup(BaseTemplate) != nil {
+		name = BaseTemplate
+	}
+
+	if err := l.tpl.ExecuteTemplate(w, name, data); err != nil {
+		// Execution errors are located by the name the failing template
+		// was parsed under, e.g. "template: baseof:1:5: ...".
+		file := l.file
+		if l.base != "" && strings.Contains(err.Error(), "template: "+BaseTemplate+":") {
+			file = l.base
+		}
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	return nil
+}
+
+// layout returns a layout by name.
+func (s *Site) layout(name string) (*layout, error) {
+	ls := s.layouts[name]
+
+	switch len(ls) {
+	case 0:
+		return nil, fmt.Errorf("the template %s specified is not defined", name)
+	case 1:
+		return ls[0], nil
+	}
+
+	var files []string
+	for _, l := range ls {
+		files = append(files, l.file)
+	}
+	return nil, fmt.Errorf("the template %s is defined in multiple files: %s", name, strings.Join(files, ", "))
+}
+
+// resolveTemplate checks that a template of the page is defined. If
+// the page doesn't specify a template, the first defined template is
+// picked in this order:
+//
+//  1. page-specific, named after the page source path (e.g. "docs/install")
+//  2. section, named after the page section (e.g. "docs")
+//  3. page kind (e.g. "page" or "home")
+//  4. "_default"
+func (s *Site) resolveTemplate(p *Page) error {
+	if p.Template != "" {
+		_, err := s.layout(p.Template)
+		return err
+	}
+
+	var names []string
+	if p.key != "" {
+		names = append(names, strings.TrimSuffix(p.key, path.Ext(p.key)))
+	}
+	if sec := p.Section(); sec != "" {
+		names = append(names, sec)
+	}
+	names = append(names, p.Kind(), DefaultTemplate)
+
+	for _, name := range names {
+		if _, ok := s.layouts[name]; ok {
+			p.Template = name
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no template found for the page (tried %s)", strings.Join(names, ", "))
+}
+
+// partial executes the partial template name with data.
+func (s *Site) partial(name string, data ...interface{}) (template.HTML, error) {
+	if len(data) > 1 {
+		return "", fmt.Errorf("partial %s: too many arguments", name)
+	}
+
+	var d interface{}
+	if len(data) == 1 {
+		d = data[0]
+	}
+
+	var buf bytes.Buffer
+	if err := s.tpl.ExecuteTemplate(&buf, path.Join(PartialsDir, name), d); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
@@ -0,0 +1,103 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.astrophena.name/gen/fileutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ThemeConfigFile is a name of the optional theme configuration file.
+const ThemeConfigFile = "theme.yaml"
+
+// themeConfig represents a theme configuration.
+type themeConfig struct {
+	// Inherits is a name of the parent theme.
+	Inherits string `yaml:"inherits"`
+}
+
+// loadThemes returns directories of the theme name and themes it
+// inherits from, starting with the theme itself.
+func (s *Site) loadThemes(name string) ([]string, error) {
+	var (
+		dirs []string
+		seen = make(map[string]bool)
+	)
+	for name != "" {
+		if seen[name] {
+			return nil, fmt.Errorf("theme %s: inheritance cycle", name)
+		}
+		seen[name] = true
+
+		dir := filepath.Join(s.themesDir(), name)
+		if !fileutil.Exists(dir) {
+			return nil, fmt.Errorf("theme %s: %s does not exist", name, dir)
+		}
+		dirs = append(dirs, dir)
+
+		cfg := &themeConfig{}
+		if path := filepath.Join(dir, ThemeConfigFile); fileutil.Exists(path) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+				return nil, fmt.Errorf("%s: failed to parse: %w", path, err)
+			}
+		}
+		name = cfg.Inherits
+	}
+	return dirs, nil
+}
+
+// lookupDirs returns existing directories named name of the site and
+// its themes, from the site to the most basic theme.
+func (s *Site) lookupDirs(name string) []string {
+	var dirs []string
+	for _, root := range append([]string{s.src}, s.themes...) {
+		if dir := filepath.Join(root, name); fileutil.Exists(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// layeredFiles returns files from dirs with extensions exts, keyed by
+// their path relative to the directory they were found in. A file in
+// an earlier directory overrides the file at the same path in later
+// directories.
+func layeredFiles(dirs []string, exts ...string) (map[string]string, error) {
+	files := make(map[string]string)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ff, err := fileutil.Files(dirs[i], exts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range ff {
+			rel, err := filepath.Rel(dirs[i], f)
+			if err != nil {
+				return nil, err
+			}
+			files[filepath.ToSlash(rel)] = f
+		}
+	}
+	return files, nil
+}
+
+// sortedKeys returns keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+// © 2020 Ilya Mateyko. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE.md file.
+
+package site
+
+import (
+	"path"
+	"strings"
+)
+
+// buildTree links sorted site pages to their parents and children.
+//
+// A parent of the page is the index page (index.md or index.html) of
+// its directory under the pages directory, or of the nearest directory
+// above. If no directory has one, the parent is the nearest page up the
+// URL hierarchy (for "docs/setup/install/" it's "docs/setup/", then
+// "docs/"), and then the page from the top-level index file.
+func (s *Site) buildTree() {
+	byURL := make(map[string]*Page)
+	for _, p := range s.pages {
+		p.parent, p.children = nil, nil
+		if _, ok := byURL[uriPath(p.URI)]; !ok {
+			byURL[uriPath(p.URI)] = p
+		}
+	}
+
+	for _, p := range s.pages {
+		p.parent = s.findParent(p, byURL)
+	}
+
+	// Pages that are parents of each other through both hierarchies
+	// would have infinite ancestors.
+	for _, p := range s.pages {
+		seen := map[*Page]bool{p: true}
+		for a := p.parent; a != nil; a = a.parent {
+			if seen[a] {
+				p.parent = nil
+				break
+			}
+			seen[a] = true
+		}
+	}
+
+	for _, p := range s.pages {
+		if p.parent != nil {
+			p.parent.children = append(p.parent.children, p)
+		}
+	}
+}
+
+func (s *Site) findParent(p *Page, byURL map[string]*Page) *Page {
+	// Index pages belong to the directory above.
+	dir := path.Dir(p.key)
+	if isIndex(p.key) {
+		dir = path.Dir(dir)
+	}
+	for ; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if i := s.indexPage(dir); i != nil && i != p {
+			return i
+		}
+	}
+
+	u := strings.TrimSuffix(uriPath(p.URI), "/")
+	for u != "" {
+		if i := strings.LastIndexByte(u, '/'); i >= 0 {
+			u = u[:i]
+		} else {
+			u = ""
+		}
+		if u == "" {
+			break
+		}
+		if parent, ok := byURL[u+"/"]; ok && parent != p {
+			return parent
+		}
+	}
+
+	if home := s.indexPage("."); home != nil && home != p {
+		return home
+	}
+	return nil
+}
+
+// indexPage returns the page in the site language from the index file
+// of the directory dir under the pages directory, or nil.
+func (s *Site) indexPage(dir string) *Page {
+	for _, ext := range SupportedFormats {
+		for _, p := range s.translations[path.Join(dir, "index"+ext)] {
+			if p.Lang == s.lang {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+func isIndex(file string) bool {
+	return strings.TrimSuffix(path.Base(file), path.Ext(file)) == "index"
+}
+
+// Parent returns the parent page, or nil for the top-level page.
+func (p *Page) Parent() *Page { return p.parent }
+
+// Ancestors returns the parent page, its parent and so on, starting
+// from the top-level page. It's handy for breadcrumbs.
+func (p *Page) Ancestors() []*Page {
+	var pages []*Page
+	for a := p.parent; a != nil; a = a.parent {
+		pages = append([]*Page{a}, pages...)
+	}
+	return pages
+}
+
+// Children returns pages whose parent is the page, in the order of
+// .Site.Pages.
+func (p *Page) Children() []*Page { return p.children }